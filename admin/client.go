@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	adminClientTimeout time.Duration = 30 * time.Second
+	// host part of URLs is ignored, requests go to the socket
+	adminClientBaseURL string = "http://admin"
+)
+
+// AdminClient is a client of the admin API
+type AdminClient struct {
+	httpClient *http.Client
+}
+
+// NewAdminClient creates a new AdminClient connecting to the Unix socket
+func NewAdminClient(socketPath string) *AdminClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &AdminClient{
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   adminClientTimeout,
+		},
+	}
+}
+
+// ListCacheEntries returns auth cache entries
+func (client *AdminClient) ListCacheEntries() ([]CacheEntry, error) {
+	entries := []CacheEntry{}
+	err := client.request(http.MethodGet, CachePath, nil, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// InvalidateCacheDN invalidates auth cache of the DN
+func (client *AdminClient) InvalidateCacheDN(dn string) (int, error) {
+	result := InvalidateResult{}
+	err := client.request(http.MethodDelete, CacheDNPath, url.Values{"dn": []string{dn}}, &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Invalidated, nil
+}
+
+// InvalidateCacheGroup invalidates auth cache of members of the iRODS group
+func (client *AdminClient) InvalidateCacheGroup(group string) (int, error) {
+	result := InvalidateResult{}
+	err := client.request(http.MethodDelete, CacheGroupPath, url.Values{"group": []string{group}}, &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Invalidated, nil
+}
+
+// FlushCache flushes auth cache
+func (client *AdminClient) FlushCache() (int, error) {
+	result := InvalidateResult{}
+	err := client.request(http.MethodDelete, CachePath, nil, &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Invalidated, nil
+}
+
+// ListLockouts returns DNs and source IPs locked out
+func (client *AdminClient) ListLockouts() ([]Lockout, error) {
+	lockouts := []Lockout{}
+	err := client.request(http.MethodGet, LockoutsPath, nil, &lockouts)
+	if err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// GetStats returns counters of the auth cache and failed binds
+func (client *AdminClient) GetStats() (*Stats, error) {
+	stats := Stats{}
+	err := client.request(http.MethodGet, StatsPath, nil, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (client *AdminClient) request(method string, path string, query url.Values, result interface{}) error {
+	requestURL := adminClientBaseURL + path
+	if len(query) > 0 {
+		requestURL = requestURL + "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, requestURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request admin API - %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errResult := ErrorResult{}
+		json.NewDecoder(resp.Body).Decode(&errResult)
+		return fmt.Errorf("admin API returned %d - %s", resp.StatusCode, errResult.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
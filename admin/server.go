@@ -0,0 +1,300 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/ldap"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CachePath is a path of auth cache API
+	CachePath string = "/cache"
+	// CacheDNPath is a path of auth cache API for a DN
+	CacheDNPath string = "/cache/dn"
+	// CacheGroupPath is a path of auth cache API for an iRODS group
+	CacheGroupPath string = "/cache/group"
+	// LockoutsPath is a path of API listing DNs and source IPs locked out
+	LockoutsPath string = "/lockouts"
+	// StatsPath is a path of API returning counters of the auth cache and failed binds
+	StatsPath string = "/stats"
+)
+
+// CacheManager manages auth cache
+type CacheManager interface {
+	ListCacheEntries() []ldap.AuthCacheEntry
+	InvalidateCacheDN(dn string) bool
+	InvalidateCacheGroup(group string) int
+	FlushCache() int
+}
+
+// GuardManager reports failed binds
+type GuardManager interface {
+	ListLockouts() []ldap.AuthLockout
+	GetAuthGuardStats() ldap.AuthGuardStats
+}
+
+// Manager is the service managed by the admin API
+type Manager interface {
+	CacheManager
+	GuardManager
+}
+
+// CacheEntry is an auth cache entry returned by the admin API
+type CacheEntry struct {
+	DN       string    `json:"dn"`
+	Username string    `json:"username"`
+	Zone     string    `json:"zone"`
+	Groups   []string  `json:"groups"`
+	Verified time.Time `json:"verified"`
+	LastUsed time.Time `json:"last_used"`
+	Expires  time.Time `json:"expires"`
+	Age      int64     `json:"age"` // seconds since the last verification
+}
+
+// InvalidateResult is a result of invalidation returned by the admin API
+type InvalidateResult struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// Lockout is a DN or a source IP locked out, returned by the admin API
+type Lockout struct {
+	Key         string    `json:"key"` // "dn:<DN>" or "ip:<IP>"
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// Stats holds counters returned by the admin API
+type Stats struct {
+	CacheEntries         int    `json:"cache_entries"`
+	NegativeCacheEntries int    `json:"negative_cache_entries"`
+	NegativeCacheHits    uint64 `json:"negative_cache_hits"`
+	LockoutRejects       uint64 `json:"lockout_rejects"`
+	Lockouts             uint64 `json:"lockouts"`
+}
+
+// ErrorResult is an error returned by the admin API
+type ErrorResult struct {
+	Error string `json:"error"`
+}
+
+// AdminServer serves the admin API over a local Unix socket
+type AdminServer struct {
+	socketPath string
+	manager    Manager
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+// NewAdminServer creates a new AdminServer
+func NewAdminServer(socketPath string, manager Manager) *AdminServer {
+	server := &AdminServer{
+		socketPath: socketPath,
+		manager:    manager,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(CachePath, server.handleCache)
+	mux.HandleFunc(CacheDNPath, server.handleCacheDN)
+	mux.HandleFunc(CacheGroupPath, server.handleCacheGroup)
+	mux.HandleFunc(LockoutsPath, server.handleLockouts)
+	mux.HandleFunc(StatsPath, server.handleStats)
+
+	server.httpServer = &http.Server{
+		Handler: mux,
+	}
+
+	return server
+}
+
+// Start starts serving the admin API in background
+func (server *AdminServer) Start() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "admin",
+		"struct":   "AdminServer",
+		"function": "Start",
+	})
+
+	// remove a stale socket, but nothing else
+	if info, err := os.Lstat(server.socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("admin socket path %s exists and is not a socket", server.socketPath)
+		}
+
+		err = os.Remove(server.socketPath)
+		if err != nil {
+			return fmt.Errorf("failed to remove stale admin socket %s - %v", server.socketPath, err)
+		}
+	}
+
+	// created in a private directory and moved in place, so only the service user can ever connect
+	socketDir, err := ioutil.TempDir(filepath.Dir(server.socketPath), ".admin-socket-")
+	if err != nil {
+		return fmt.Errorf("failed to create a directory for admin socket %s - %v", server.socketPath, err)
+	}
+	defer os.RemoveAll(socketDir)
+
+	tempSocketPath := filepath.Join(socketDir, "admin.sock")
+	listener, err := net.Listen("unix", tempSocketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on admin socket %s - %v", server.socketPath, err)
+	}
+
+	// the socket is removed by Stop at the path moved to
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(tempSocketPath, 0600)
+	if err == nil {
+		err = os.Rename(tempSocketPath, server.socketPath)
+	}
+
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to create admin socket %s - %v", server.socketPath, err)
+	}
+
+	server.listener = listener
+
+	logger.Infof("Serving admin API on %s", server.socketPath)
+
+	go func() {
+		err := server.httpServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Error("failed to serve admin API")
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops serving the admin API
+func (server *AdminServer) Stop() {
+	if server.listener == nil {
+		return
+	}
+
+	server.httpServer.Close()
+	os.Remove(server.socketPath)
+	server.listener = nil
+}
+
+func (server *AdminServer) handleCache(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		now := time.Now()
+		entries := []CacheEntry{}
+		for _, entry := range server.manager.ListCacheEntries() {
+			entries = append(entries, CacheEntry{
+				DN:       entry.DN,
+				Username: entry.Username,
+				Zone:     entry.Zone,
+				Groups:   entry.Groups,
+				Verified: entry.Verified,
+				LastUsed: entry.LastUsed,
+				Expires:  entry.Expires,
+				Age:      int64(now.Sub(entry.Verified).Seconds()),
+			})
+		}
+		writeJSON(w, http.StatusOK, entries)
+	case http.MethodDelete:
+		flushed := server.manager.FlushCache()
+		writeAudit("flushed auth cache", flushed)
+		writeJSON(w, http.StatusOK, &InvalidateResult{Invalidated: flushed})
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, &ErrorResult{Error: "method not allowed"})
+	}
+}
+
+func (server *AdminServer) handleCacheDN(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, &ErrorResult{Error: "method not allowed"})
+		return
+	}
+
+	dn := r.URL.Query().Get("dn")
+	if len(dn) == 0 {
+		writeJSON(w, http.StatusBadRequest, &ErrorResult{Error: "dn must be given"})
+		return
+	}
+
+	invalidated := 0
+	if server.manager.InvalidateCacheDN(dn) {
+		invalidated = 1
+	}
+
+	writeAudit(fmt.Sprintf("invalidated auth cache of DN %s", dn), invalidated)
+	writeJSON(w, http.StatusOK, &InvalidateResult{Invalidated: invalidated})
+}
+
+func (server *AdminServer) handleCacheGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, &ErrorResult{Error: "method not allowed"})
+		return
+	}
+
+	group := r.URL.Query().Get("group")
+	if len(group) == 0 {
+		writeJSON(w, http.StatusBadRequest, &ErrorResult{Error: "group must be given"})
+		return
+	}
+
+	invalidated := server.manager.InvalidateCacheGroup(group)
+	writeAudit(fmt.Sprintf("invalidated auth cache of group %s", group), invalidated)
+	writeJSON(w, http.StatusOK, &InvalidateResult{Invalidated: invalidated})
+}
+
+func (server *AdminServer) handleLockouts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, &ErrorResult{Error: "method not allowed"})
+		return
+	}
+
+	lockouts := []Lockout{}
+	for _, lockout := range server.manager.ListLockouts() {
+		lockouts = append(lockouts, Lockout{
+			Key:         lockout.Key,
+			Failures:    lockout.Failures,
+			LastFailure: lockout.LastFailure,
+			LockedUntil: lockout.LockedUntil,
+		})
+	}
+	writeJSON(w, http.StatusOK, lockouts)
+}
+
+func (server *AdminServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, &ErrorResult{Error: "method not allowed"})
+		return
+	}
+
+	guardStats := server.manager.GetAuthGuardStats()
+	writeJSON(w, http.StatusOK, &Stats{
+		CacheEntries:         len(server.manager.ListCacheEntries()),
+		NegativeCacheEntries: guardStats.NegativeCacheEntries,
+		NegativeCacheHits:    guardStats.NegativeCacheHits,
+		LockoutRejects:       guardStats.LockoutRejects,
+		Lockouts:             guardStats.Lockouts,
+	})
+}
+
+func writeAudit(message string, count int) {
+	log.WithFields(log.Fields{
+		"package": "admin",
+		"audit":   true,
+		"entries": count,
+	}).Info(message)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,16 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"gopkg.in/natefinch/lumberjack.v2"
-	"gopkg.in/yaml.v2"
 
 	"github.com/cyverse/ldap-irods-auth/commons"
 	log "github.com/sirupsen/logrus"
@@ -18,9 +15,29 @@ import (
 
 const (
 	ChildProcessArgument = "child_process"
+
+	// ServeCommand runs the service, the default without a subcommand
+	ServeCommand = "serve"
+	// VersionCommand prints version information
+	VersionCommand = "version"
 )
 
-func processArguments() (*commons.Config, io.WriteCloser, error, bool) {
+// printUsage prints usage of the service and subcommands
+func printUsage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage: %s [%s] [-config FILE] [options]\n", os.Args[0], ServeCommand)
+	fmt.Fprintf(output, "       %s %s <check|print> [-config FILE] [options]\n", os.Args[0], ConfigCommand)
+	fmt.Fprintf(output, "       %s %s [-config FILE] [options] BIND_DN\n", os.Args[0], TestAuthCommand)
+	fmt.Fprintf(output, "       %s %s [-config FILE] [options] FILTER [ATTRIBUTE...]\n", os.Args[0], TestSearchCommand)
+	fmt.Fprintf(output, "       %s %s [options] <list|invalidate DN|invalidate-group GROUP|flush|lockouts|stats>\n", os.Args[0], CacheCommand)
+	fmt.Fprintf(output, "       %s %s [-config FILE] [options]\n", os.Args[0], StatusCommand)
+	fmt.Fprintf(output, "       %s %s [-config FILE] [-timeout SECONDS] [options]\n", os.Args[0], StopCommand)
+	fmt.Fprintf(output, "       %s %s\n", os.Args[0], VersionCommand)
+	fmt.Fprintln(output, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+func processArguments(args []string) (*commons.Config, io.WriteCloser, error, bool) {
 	logger := log.WithFields(log.Fields{
 		"package":  "main",
 		"function": "processArguments",
@@ -28,20 +45,25 @@ func processArguments() (*commons.Config, io.WriteCloser, error, bool) {
 
 	var version bool
 	var help bool
+	var childProcess bool
 	var configFilePath string
 
-	config := commons.NewDefaultConfig()
+	configFlags := commons.ConfigFlags{}
 
 	// Parse parameters
 	flag.BoolVar(&version, "version", false, "Print client version information")
 	flag.BoolVar(&version, "v", false, "Print client version information (shorthand form)")
 	flag.BoolVar(&help, "h", false, "Print help")
 	flag.StringVar(&configFilePath, "config", "", "Set Config YAML File")
-	flag.BoolVar(&config.Foreground, "f", false, "Run in foreground")
-	flag.BoolVar(&config.ChildProcess, ChildProcessArgument, false, "")
-	flag.StringVar(&config.LogPath, "log", commons.LogFilePathDefault, "Set log file path")
+	flag.BoolVar(&childProcess, ChildProcessArgument, false, "")
 
-	flag.Parse()
+	// every config field, overriding the YAML file and env
+	commons.AddConfigFlags(flag.CommandLine, configFlags)
+	commons.AddConfigFlagAlias(flag.CommandLine, configFlags, "f", "foreground", "Run in foreground")
+	commons.AddConfigFlagAlias(flag.CommandLine, configFlags, "log", "log_path", "Set log file path")
+
+	flag.Usage = printUsage
+	flag.CommandLine.Parse(args)
 
 	if version {
 		info, err := commons.GetVersionJSON()
@@ -59,6 +81,23 @@ func processArguments() (*commons.Config, io.WriteCloser, error, bool) {
 		return nil, nil, nil, true
 	}
 
+	if len(configFilePath) > 0 && configFilePath != "-" {
+		// the background process may reload it
+		configFileAbsPath, err := filepath.Abs(configFilePath)
+		if err != nil {
+			logger.WithError(err).Errorf("failed to access the local yaml file %s", configFilePath)
+			return nil, nil, err, true
+		}
+		configFilePath = configFileAbsPath
+	}
+
+	// defaults < YAML file < env < flags
+	config, err := commons.LoadConfig(configFilePath, configFlags)
+	if err != nil {
+		logger.WithError(err).Error("failed to read configuration")
+		return nil, nil, err, true
+	}
+
 	var logWriter io.WriteCloser
 	if config.LogPath == "-" || len(config.LogPath) == 0 {
 		log.SetOutput(os.Stderr)
@@ -72,61 +111,6 @@ func processArguments() (*commons.Config, io.WriteCloser, error, bool) {
 
 	logger.Infof("Logging to %s", config.LogPath)
 
-	if len(configFilePath) == 0 {
-		// read from Environmental variables
-		envConfig, err := commons.NewConfigFromENV()
-		if err != nil {
-			logger.WithError(err).Error("failed to read Environmental Variables")
-			return nil, logWriter, err, true
-		}
-
-		envConfig.Foreground = config.Foreground
-		// overwrite
-		config = envConfig
-	} else if configFilePath == "-" {
-		// read from stdin
-		stdinReader := bufio.NewReader(os.Stdin)
-		yamlBytes, err := ioutil.ReadAll(stdinReader)
-		if err != nil {
-			logger.WithError(err).Error("failed to read STDIN")
-			return nil, logWriter, err, true
-		}
-
-		err = yaml.Unmarshal(yamlBytes, &config)
-		if err != nil {
-			return nil, logWriter, fmt.Errorf("failed to unmarshal YAML - %v", err), true
-		}
-	} else {
-		// read config
-		configFileAbsPath, err := filepath.Abs(configFilePath)
-		if err != nil {
-			logger.WithError(err).Errorf("failed to access the local yaml file %s", configFilePath)
-			return nil, logWriter, err, true
-		}
-
-		fileinfo, err := os.Stat(configFileAbsPath)
-		if err != nil {
-			logger.WithError(err).Errorf("failed to access the local yaml file %s", configFileAbsPath)
-			return nil, logWriter, err, true
-		}
-
-		if fileinfo.IsDir() {
-			logger.WithError(err).Errorf("local yaml file %s is not a file", configFileAbsPath)
-			return nil, logWriter, fmt.Errorf("local yaml file %s is not a file", configFileAbsPath), true
-		}
-
-		yamlBytes, err := ioutil.ReadFile(configFileAbsPath)
-		if err != nil {
-			logger.WithError(err).Errorf("failed to read the local yaml file %s", configFileAbsPath)
-			return nil, logWriter, err, true
-		}
-
-		err = yaml.Unmarshal(yamlBytes, &config)
-		if err != nil {
-			return nil, logWriter, fmt.Errorf("failed to unmarshal YAML - %v", err), true
-		}
-	}
-
 	return config, logWriter, nil, false
 }
 
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cyverse/ldap-irods-auth/admin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CacheCommand is a subcommand for auth cache administration
+	CacheCommand = "cache"
+)
+
+// cacheMain handles the cache subcommand, talking to the running service over the admin socket
+func cacheMain(args []string) {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "cacheMain",
+	})
+
+	var socketPath string
+
+	flagSet := flag.NewFlagSet(CacheCommand, flag.ExitOnError)
+	configFlags := addCommandConfigFlags(flagSet)
+	flagSet.StringVar(&socketPath, "admin_socket", "", "Set admin socket path, overrides config")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s [options] <list|invalidate DN|invalidate-group GROUP|flush|lockouts|stats>\n", os.Args[0], CacheCommand)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	if len(socketPath) == 0 {
+		config, err := configFlags.load()
+		if err != nil {
+			logger.WithError(err).Fatal("failed to read configuration")
+		}
+		socketPath = config.AdminSocketPath
+	}
+
+	if len(socketPath) == 0 {
+		logger.Fatal("admin socket is not configured")
+	}
+
+	client := admin.NewAdminClient(socketPath)
+
+	err := runCacheCommand(client, flagSet.Args())
+	if err != nil {
+		if err == flag.ErrHelp {
+			flagSet.Usage()
+			os.Exit(2)
+		}
+		logger.WithError(err).Fatal("failed to run cache command")
+	}
+}
+
+func runCacheCommand(client *admin.AdminClient, args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+
+	switch args[0] {
+	case "list":
+		entries, err := client.ListCacheEntries()
+		if err != nil {
+			return err
+		}
+		return printJSON(entries)
+	case "lockouts":
+		lockouts, err := client.ListLockouts()
+		if err != nil {
+			return err
+		}
+		return printJSON(lockouts)
+	case "stats":
+		stats, err := client.GetStats()
+		if err != nil {
+			return err
+		}
+		return printJSON(stats)
+	case "invalidate":
+		if len(args) != 2 {
+			return flag.ErrHelp
+		}
+
+		invalidated, err := client.InvalidateCacheDN(args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Invalidated %d entries\n", invalidated)
+	case "invalidate-group":
+		if len(args) != 2 {
+			return flag.ErrHelp
+		}
+
+		invalidated, err := client.InvalidateCacheGroup(args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Invalidated %d entries\n", invalidated)
+	case "flush":
+		flushed, err := client.FlushCache()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Flushed %d entries\n", flushed)
+	default:
+		return flag.ErrHelp
+	}
+
+	return nil
+}
+
+// printJSON prints the result of the admin API as indented JSON
+func printJSON(v interface{}) error {
+	vJSON, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(vJSON))
+	return nil
+}
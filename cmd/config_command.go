@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ConfigCommand is a subcommand for config files
+	ConfigCommand = "config"
+	// ConfigCheckCommand checks the config and exits non-zero if it is invalid
+	ConfigCheckCommand = "check"
+	// ConfigPrintCommand prints the effective config with secrets redacted
+	ConfigPrintCommand = "print"
+)
+
+// commandConfigFlags are flags of subcommands loading the config as the service does
+type commandConfigFlags struct {
+	configFilePath string
+	configFlags    commons.ConfigFlags
+}
+
+// addCommandConfigFlags registers -config and a flag per config field on the flag set
+func addCommandConfigFlags(flagSet *flag.FlagSet) *commandConfigFlags {
+	flags := &commandConfigFlags{
+		configFlags: commons.ConfigFlags{},
+	}
+
+	flagSet.StringVar(&flags.configFilePath, "config", "", "Set Config YAML File")
+	commons.AddConfigFlags(flagSet, flags.configFlags)
+	return flags
+}
+
+// load loads the config from the YAML file, env and flags
+func (flags *commandConfigFlags) load() (*commons.Config, error) {
+	return commons.LoadConfig(flags.configFilePath, flags.configFlags)
+}
+
+// configMain handles the config subcommand
+func configMain(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s <%s|%s> [-config FILE] [options]\n", os.Args[0], ConfigCommand, ConfigCheckCommand, ConfigPrintCommand)
+	}
+
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case ConfigCheckCommand:
+		os.Exit(configCheckMain(args[1:]))
+	case ConfigPrintCommand:
+		os.Exit(configPrintMain(args[1:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// configCheckMain loads and validates the config as the service does, returns the exit code
+func configCheckMain(args []string) int {
+	flagSet := flag.NewFlagSet(ConfigCommand+" "+ConfigCheckCommand, flag.ExitOnError)
+	configFlags := addCommandConfigFlags(flagSet)
+	flagSet.Parse(args)
+
+	config, err := configFlags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config cannot be loaded - %v\n", err)
+		return 1
+	}
+
+	err = config.Validate()
+	if err != nil {
+		printConfigProblems(err)
+		return 1
+	}
+
+	fmt.Println("Config is valid")
+	return 0
+}
+
+// configPrintMain prints the effective config with secrets redacted, returns the exit code
+func configPrintMain(args []string) int {
+	flagSet := flag.NewFlagSet(ConfigCommand+" "+ConfigPrintCommand, flag.ExitOnError)
+	configFlags := addCommandConfigFlags(flagSet)
+	flagSet.Parse(args)
+
+	config, err := configFlags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config cannot be loaded - %v\n", err)
+		return 1
+	}
+
+	configBytes, err := yaml.Marshal(config.Redacted())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config cannot be serialized - %v\n", err)
+		return 1
+	}
+
+	fmt.Print(string(configBytes))
+	return 0
+}
+
+// printConfigProblems prints problems found in validation
+func printConfigProblems(err error) {
+	fmt.Fprintln(os.Stderr, "Config is invalid")
+	if configErr, ok := err.(*commons.ConfigError); ok {
+		for _, problem := range configErr.Problems {
+			fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "  - %v\n", err)
+}
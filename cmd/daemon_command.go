@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+const (
+	// StatusCommand reports if the service is running
+	StatusCommand = "status"
+	// StopCommand stops the running service
+	StopCommand = "stop"
+
+	// exit code of the status command if not running, as LSB init scripts
+	statusNotRunningExitCode int = 3
+
+	stopTimeoutDefault  int           = 30 // 30sec
+	stopPollingInterval time.Duration = 100 * time.Millisecond
+)
+
+// statusMain handles the status subcommand
+func statusMain(args []string) {
+	flagSet := flag.NewFlagSet(StatusCommand, flag.ExitOnError)
+	configFlags := addCommandConfigFlags(flagSet)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s [-config FILE] [options]\n", os.Args[0], StatusCommand)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	config := loadDaemonConfig(configFlags)
+
+	pid, err := getRunningPID(config.PIDFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if pid == 0 {
+		fmt.Println("LDAP-iRODS-Auth is not running")
+		os.Exit(statusNotRunningExitCode)
+	}
+
+	fmt.Printf("LDAP-iRODS-Auth is running, pid %d\n", pid)
+}
+
+// stopMain handles the stop subcommand, it waits until the service exits
+func stopMain(args []string) {
+	var timeout int
+
+	flagSet := flag.NewFlagSet(StopCommand, flag.ExitOnError)
+	configFlags := addCommandConfigFlags(flagSet)
+	flagSet.IntVar(&timeout, "timeout", stopTimeoutDefault, "Set seconds to wait for the service to exit")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s [-config FILE] [-timeout SECONDS] [options]\n", os.Args[0], StopCommand)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	config := loadDaemonConfig(configFlags)
+
+	pid, err := getRunningPID(config.PIDFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if pid == 0 {
+		fmt.Println("LDAP-iRODS-Auth is not running")
+		return
+	}
+
+	err = syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send a signal to pid %d - %v\n", pid, err)
+		os.Exit(1)
+	}
+
+	// the lock is released when the process exits
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for time.Now().Before(deadline) {
+		runningPID, err := getRunningPID(config.PIDFilePath)
+		if err == nil && runningPID == 0 {
+			fmt.Printf("Stopped LDAP-iRODS-Auth, pid %d\n", pid)
+			return
+		}
+
+		time.Sleep(stopPollingInterval)
+	}
+
+	fmt.Fprintf(os.Stderr, "LDAP-iRODS-Auth, pid %d, did not exit in %d seconds\n", pid, timeout)
+	os.Exit(1)
+}
+
+// loadDaemonConfig loads the config to find the PID file, exits if it cannot be loaded
+func loadDaemonConfig(configFlags *commandConfigFlags) *commons.Config {
+	config, err := configFlags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config cannot be loaded - %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(config.PIDFilePath) == 0 {
+		fmt.Fprintln(os.Stderr, "PID file is not configured")
+		os.Exit(1)
+	}
+	return config
+}
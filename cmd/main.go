@@ -11,6 +11,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/cyverse/ldap-irods-auth/admin"
 	"github.com/cyverse/ldap-irods-auth/commons"
 	"github.com/cyverse/ldap-irods-auth/ldap"
 	log "github.com/sirupsen/logrus"
@@ -33,6 +34,36 @@ func (w *NilWriter) Write(p []byte) (n int, err error) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case ServeCommand:
+			parentMain(os.Args[2:])
+			return
+		case VersionCommand:
+			versionMain()
+			return
+		case CacheCommand:
+			// admin subcommands
+			cacheMain(os.Args[2:])
+			return
+		case ConfigCommand:
+			configMain(os.Args[2:])
+			return
+		case TestAuthCommand:
+			testAuthMain(os.Args[2:])
+			return
+		case TestSearchCommand:
+			testSearchMain(os.Args[2:])
+			return
+		case StatusCommand:
+			statusMain(os.Args[2:])
+			return
+		case StopCommand:
+			stopMain(os.Args[2:])
+			return
+		}
+	}
+
 	// check if this is subprocess running in the background
 	isChildProc := false
 
@@ -49,9 +80,20 @@ func main() {
 		// child process
 		childMain()
 	} else {
-		// parent process
-		parentMain()
+		// parent process, serves without a subcommand for compatibility
+		parentMain(os.Args[1:])
+	}
+}
+
+// versionMain prints version information
+func versionMain() {
+	info, err := commons.GetVersionJSON()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get version info - %v\n", err)
+		os.Exit(1)
 	}
+
+	fmt.Println(info)
 }
 
 // RunDaemon runs LDAP-iRODS-Auth as a daemon
@@ -76,8 +118,23 @@ func parentRun(ldapExec string, config *commons.Config) error {
 		// run child process in background and pass parameters via stdin PIPE
 		// receives result from the child process
 		logger.Info("Running the process in the background mode")
+
+		// the child reports errors to its log only
+		if len(config.PIDFilePath) > 0 {
+			pid, err := getRunningPID(config.PIDFilePath)
+			if err == nil && pid > 0 {
+				err = fmt.Errorf("already running with pid %d, PID file %s is locked", pid, config.PIDFilePath)
+				logger.WithError(err).Error("failed to start a child process")
+				return err
+			}
+		}
+
 		childProcessArgument := fmt.Sprintf("-%s", ChildProcessArgument)
 		cmd := exec.Command(ldapExec, childProcessArgument)
+		// detach from the session, so the child is not signaled with the terminal
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setsid: true,
+		}
 		subStdin, err := cmd.StdinPipe()
 		if err != nil {
 			logger.WithError(err).Error("failed to communicate to background process")
@@ -100,6 +157,7 @@ func parentRun(ldapExec string, config *commons.Config) error {
 
 		logger.Infof("Process id = %d", cmd.Process.Pid)
 
+		// resolved secrets are passed over the pipe only, never logged
 		logger.Info("Sending configuration data")
 		configBytes, err := yaml.Marshal(config)
 		if err != nil {
@@ -131,15 +189,18 @@ func parentRun(ldapExec string, config *commons.Config) error {
 					childProcessFailed = true
 					break
 				} else {
-					fmt.Fprintln(os.Stderr, errMsg)
+					// output of the child must not expose secrets
+					fmt.Fprintln(os.Stderr, config.RedactString(errMsg))
 				}
 			} else {
-				// check err
+				// the child exited before reporting
 				if subOutputScanner.Err() != nil {
 					logger.Error(subOutputScanner.Err().Error())
-					childProcessFailed = true
-					break
+				} else {
+					logger.Error("background process exited before starting")
 				}
+				childProcessFailed = true
+				break
 			}
 		}
 
@@ -161,14 +222,14 @@ func parentRun(ldapExec string, config *commons.Config) error {
 }
 
 // parentMain handles command-line parameters and run parent process
-func parentMain() {
+func parentMain(args []string) {
 	logger := log.WithFields(log.Fields{
 		"package":  "main",
 		"function": "parentMain",
 	})
 
 	// parse argument
-	config, logWriter, err, exit := processArguments()
+	config, logWriter, err, exit := processArguments(args)
 	if err != nil {
 		logger.WithError(err).Error("failed to process arguments")
 		if exit {
@@ -218,9 +279,12 @@ func childMain() {
 		os.Exit(1)
 	}
 
-	config, err := commons.NewConfigFromYAML(configBytes)
+	// secrets are resolved by the parent, not resolved again
+	config := commons.NewDefaultConfig()
+	err = yaml.Unmarshal(configBytes, config)
 	if err != nil {
-		logger.WithError(err).Error("failed to read configuration")
+		// YAML errors may quote values, including secrets
+		logger.Error("failed to unmarshal configuration")
 		fmt.Fprintln(os.Stderr, InterProcessCommunicationFinishError)
 		os.Exit(1)
 	}
@@ -258,6 +322,23 @@ func run(config *commons.Config, isChildProcess bool) error {
 		"function": "run",
 	})
 
+	setLogLevel(config.LogLevel)
+
+	var pidFile *PIDFile
+	if len(config.PIDFilePath) > 0 {
+		acquiredPIDFile, err := acquirePIDFile(config.PIDFilePath)
+		if err != nil {
+			logger.WithError(err).Error("failed to acquire the PID file")
+			if isChildProcess {
+				fmt.Fprintln(os.Stderr, InterProcessCommunicationFinishError)
+			}
+			return err
+		}
+
+		pidFile = acquiredPIDFile
+		defer pidFile.Release()
+	}
+
 	// run a service
 	svc, err := ldap.NewLDAP(config)
 	if err != nil {
@@ -268,20 +349,68 @@ func run(config *commons.Config, isChildProcess bool) error {
 		return err
 	}
 
+	var adminServer *admin.AdminServer
+	if len(config.AdminSocketPath) > 0 {
+		adminServer = admin.NewAdminServer(config.AdminSocketPath, svc)
+		err = adminServer.Start()
+		if err != nil {
+			logger.WithError(err).Error("failed to start the admin API")
+			if isChildProcess {
+				fmt.Fprintln(os.Stderr, InterProcessCommunicationFinishError)
+			}
+			svc.Destroy()
+			return err
+		}
+		defer adminServer.Stop()
+	}
+
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGQUIT)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGQUIT, syscall.SIGHUP)
 
 	go func() {
-		receivedSignal := <-signalChan
+		currentConfig := config
+		stopping := false
+		for receivedSignal := range signalChan {
+			if receivedSignal == syscall.SIGHUP {
+				// reload failures are reported, the service keeps running
+				notifySystemd(commons.SystemdNotifyReloading, commons.SystemdStatus("Reloading the configuration"))
+				currentConfig = reloadConfig(currentConfig, svc)
+				notifySystemd(commons.SystemdNotifyReady, commons.SystemdStatus("Serving"))
+				continue
+			}
+
+			if stopping {
+				logger.Warnf("received signal (%s) while draining connections, terminating LDAP-iRODS-Auth immediately", receivedSignal.String())
+				pidFile.Release()
+				os.Exit(1)
+			}
+
+			stopping = true
+			logger.Infof("received signal (%s), terminating LDAP-iRODS-Auth", receivedSignal.String())
+			notifySystemdStopping()
+			if isChildProcess {
+				fmt.Fprintln(os.Stderr, InterProcessCommunicationFinishError)
+			}
+
+			if adminServer != nil {
+				adminServer.Stop()
+			}
+
+			// svc.Start returns once connections are drained, another signal terminates immediately
+			go svc.Destroy()
+		}
+	}()
 
-		logger.Infof("received signal (%s), terminating LDAP-iRODS-Auth", receivedSignal.String())
+	// the parent reports success once clients can connect
+	err = svc.Listen()
+	if err != nil {
+		logger.WithError(err).Error("failed to listen, terminating LDAP-iRODS-Auth")
 		if isChildProcess {
 			fmt.Fprintln(os.Stderr, InterProcessCommunicationFinishError)
 		}
-
 		svc.Destroy()
-		os.Exit(0)
-	}()
+		return err
+	}
 
 	if isChildProcess {
 		fmt.Fprintln(os.Stdout, InterProcessCommunicationFinishSuccess)
@@ -290,17 +419,37 @@ func run(config *commons.Config, isChildProcess bool) error {
 			var nilWriter NilWriter
 			log.SetOutput(&nilWriter)
 		}
+
+		detachStdio()
 	}
 
+	notifySystemd(commons.SystemdNotifyReady, commons.SystemdStatus("Serving"))
+	stopWatchdog := startSystemdWatchdog(config, svc)
+	defer stopWatchdog()
+
 	err = svc.Start()
 	if err != nil {
 		logger.WithError(err).Error("failed to start the service, terminating LDAP-iRODS-Auth")
+		notifySystemdStopping()
 		svc.Destroy()
 		return err
 	}
 
 	// returns if fails, or stopped.
 	logger.Info("Service stopped, terminating LDAP-iRODS-Auth")
+	notifySystemdStopping()
 	svc.Destroy()
 	return nil
 }
+
+// detachStdio replaces stdio of the background process, pipes to the parent are closed once it reports
+func detachStdio() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+
+	os.Stdin = devNull
+	os.Stdout = devNull
+	os.Stderr = devNull
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PIDFile is a PID file locked while the service runs
+type PIDFile struct {
+	path string
+	file *os.File
+}
+
+// acquirePIDFile creates the PID file and locks it, fails if another process holds the lock
+func acquirePIDFile(path string) (*PIDFile, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PID file %s - %v", path, err)
+		}
+
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			file.Close()
+			if err == syscall.EWOULDBLOCK {
+				pid, _ := readPID(path)
+				return nil, fmt.Errorf("already running with pid %d, PID file %s is locked", pid, path)
+			}
+			return nil, fmt.Errorf("failed to lock PID file %s - %v", path, err)
+		}
+
+		// the file may be removed by the previous owner while waiting for the lock
+		if !isSameFile(file, path) {
+			file.Close()
+			continue
+		}
+
+		err = file.Truncate(0)
+		if err == nil {
+			_, err = file.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write PID file %s - %v", path, err)
+		}
+
+		return &PIDFile{
+			path: path,
+			file: file,
+		}, nil
+	}
+}
+
+// Release removes the PID file and unlocks it
+func (pidFile *PIDFile) Release() {
+	if pidFile == nil || pidFile.file == nil {
+		return
+	}
+
+	// removed before unlocking, so a new owner never locks a removed file
+	os.Remove(pidFile.path)
+	pidFile.file.Close()
+	pidFile.file = nil
+}
+
+// getRunningPID returns the pid of the process holding the PID file, 0 if no process holds it
+func getRunningPID(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open PID file %s - %v", path, err)
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		// stale
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return 0, nil
+	}
+
+	if err != syscall.EWOULDBLOCK {
+		return 0, fmt.Errorf("failed to check lock of PID file %s - %v", path, err)
+	}
+
+	return readPID(path)
+}
+
+// readPID reads the pid written in the PID file
+func readPID(path string) (int, error) {
+	pidBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read PID file %s - %v", path, err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse PID file %s - %v", path, err)
+	}
+	return pid, nil
+}
+
+// isSameFile checks if the path still refers to the file opened
+func isSameFile(file *os.File, path string) bool {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fileInfo, pathInfo)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	"github.com/cyverse/ldap-irods-auth/ldap"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadReloadConfig loads the config again from the sources it was loaded at start
+func loadReloadConfig(config *commons.Config) (*commons.Config, error) {
+	if config.ConfigFilePath == "-" {
+		return nil, fmt.Errorf("config read from STDIN cannot be reloaded")
+	}
+
+	newConfig, err := commons.LoadConfig(config.ConfigFilePath, config.ConfigFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	// settings of the process are kept
+	newConfig.Foreground = config.Foreground
+	newConfig.ChildProcess = config.ChildProcess
+
+	err = newConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration - %v", err)
+	}
+	return newConfig, nil
+}
+
+// reloadConfig re-reads and applies reloadable settings of the config, returns the config in effect.
+// The service keeps running with the current config if reload fails
+func reloadConfig(config *commons.Config, svc *ldap.LDAPService) *commons.Config {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "reloadConfig",
+	})
+
+	logger.Info("Reloading the configuration")
+
+	newConfig, err := loadReloadConfig(config)
+	if err != nil {
+		logger.WithError(err).Error("failed to reload the configuration, keeping the current configuration")
+		return config
+	}
+
+	// settings requiring a restart stay as they are in effect, so later reloads warn about them again
+	mergedConfig := config.MergeReloadable(newConfig)
+	err = mergedConfig.Validate()
+	if err != nil {
+		logger.WithError(err).Error("failed to reload the configuration, reloadable settings are invalid with settings in effect, keeping the current configuration")
+		return config
+	}
+
+	err = svc.Reload(mergedConfig)
+	if err != nil {
+		logger.WithError(err).Error("failed to reload the configuration, keeping the current configuration")
+		return config
+	}
+
+	setLogLevel(mergedConfig.LogLevel)
+
+	if config.RequiresRestart(newConfig) {
+		logger.Warn("Some of the changed settings are applied only after restart")
+	}
+	return mergedConfig
+}
+
+// setLogLevel sets the log level, the level is validated with the config
+func setLogLevel(level string) {
+	logLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return
+	}
+	log.SetLevel(logLevel)
+}
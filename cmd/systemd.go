@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	"github.com/cyverse/ldap-irods-auth/ldap"
+	log "github.com/sirupsen/logrus"
+)
+
+// notifySystemd sends the states to systemd if it runs the service with notify
+func notifySystemd(states ...string) {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "notifySystemd",
+	})
+
+	_, err := commons.SystemdNotify(states...)
+	if err != nil {
+		logger.WithError(err).Warn("failed to notify systemd")
+	}
+}
+
+var (
+	systemdStoppingOnce sync.Once
+)
+
+// notifySystemdStopping reports shutdown to systemd once
+func notifySystemdStopping() {
+	systemdStoppingOnce.Do(func() {
+		notifySystemd(commons.SystemdNotifyStopping)
+	})
+}
+
+// startSystemdWatchdog notifies the systemd watchdog while the service accepts connections and iRODS is reachable,
+// if the watchdog is enabled. If the config does not require iRODS, its reachability is reported in the status only.
+// The returned function stops notifying
+func startSystemdWatchdog(config *commons.Config, svc *ldap.LDAPService) func() {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "startSystemdWatchdog",
+	})
+
+	interval, err := commons.GetSystemdWatchdogInterval()
+	if err != nil {
+		logger.WithError(err).Warn("failed to get the systemd watchdog interval, watchdog is not notified")
+		return func() {}
+	}
+
+	if interval <= 0 {
+		return func() {}
+	}
+
+	// notified twice in the interval, checks must finish before the next
+	notifyInterval := interval / 2
+	checkTimeout := notifyInterval / 4
+	logger.Infof("Notifying the systemd watchdog every %s", notifyInterval.String())
+
+	stopChan := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(notifyInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+				err := svc.CheckLiveness(checkTimeout)
+				if err != nil {
+					// systemd restarts the service if it keeps failing
+					logger.WithError(err).Warn("liveness check failed, not notifying the systemd watchdog")
+					notifySystemd(commons.SystemdStatus(fmt.Sprintf("Liveness check failed - %v", err)))
+					continue
+				}
+
+				// the status is sent each time, as reloading resets it
+				err = svc.CheckIRODS(checkTimeout)
+				if err != nil {
+					if config.WatchdogRequireIRODS {
+						logger.WithError(err).Warn("iRODS is unreachable, not notifying the systemd watchdog")
+						notifySystemd(commons.SystemdStatus(fmt.Sprintf("iRODS is unreachable - %v", err)))
+						continue
+					}
+
+					notifySystemd(commons.SystemdNotifyWatchdog, commons.SystemdStatus(fmt.Sprintf("Serving, iRODS is unreachable - %v", err)))
+					continue
+				}
+
+				notifySystemd(commons.SystemdNotifyWatchdog, commons.SystemdStatus("Serving"))
+			}
+		}
+	}()
+
+	return func() {
+		close(stopChan)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cyverse/ldap-irods-auth/admin"
+	"github.com/cyverse/ldap-irods-auth/commons"
+	"github.com/cyverse/ldap-irods-auth/ldap"
+	"golang.org/x/term"
+)
+
+const (
+	// TestAuthCommand authenticates a bind name against iRODS, showing each step
+	TestAuthCommand = "test-auth"
+	// TestSearchCommand runs a search filter against the entry model locally
+	TestSearchCommand = "test-search"
+)
+
+// testAuthMain handles the test-auth subcommand, the password is read from STDIN
+func testAuthMain(args []string) {
+	flagSet := flag.NewFlagSet(TestAuthCommand, flag.ExitOnError)
+	configFlags := addCommandConfigFlags(flagSet)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s [-config FILE] [options] BIND_DN\nThe password is read from STDIN.\n", os.Args[0], TestAuthCommand)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		os.Exit(2)
+	}
+
+	config := loadTestConfig(configFlags)
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := readPassword()
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read password - %v\n", err)
+		os.Exit(1)
+	}
+
+	svc, err := ldap.NewLDAP(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the service - %v\n", err)
+		os.Exit(1)
+	}
+	defer svc.Destroy()
+
+	authSuccess, err := svc.TestAuth(flagSet.Arg(0), password, printAuthStep)
+	if !authSuccess {
+		fmt.Printf("Result: authentication failed - %v\n", err)
+		svc.Destroy()
+		os.Exit(1)
+	}
+
+	fmt.Println("Result: authenticated")
+}
+
+// readPassword reads a line from STDIN, without echo if it is a terminal
+func readPassword() (string, error) {
+	stdinFd := int(os.Stdin.Fd())
+	if term.IsTerminal(stdinFd) {
+		password, err := term.ReadPassword(stdinFd)
+		if err != nil {
+			return "", err
+		}
+		return string(password), nil
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(password, "\r\n"), nil
+}
+
+// printAuthStep prints the result of an authentication step
+func printAuthStep(step string, result string, err error) {
+	if err != nil {
+		fmt.Printf("[fail] %s - %v\n", step, err)
+		return
+	}
+
+	if len(result) > 0 {
+		fmt.Printf("[ok]   %s: %s\n", step, result)
+		return
+	}
+	fmt.Printf("[ok]   %s\n", step)
+}
+
+// testSearchMain handles the test-search subcommand
+func testSearchMain(args []string) {
+	var baseDN string
+	var fromService bool
+
+	flagSet := flag.NewFlagSet(TestSearchCommand, flag.ExitOnError)
+	configFlags := addCommandConfigFlags(flagSet)
+	flagSet.StringVar(&baseDN, "base", "", "Set search base DN, ldap_base_dn if not given")
+	flagSet.BoolVar(&fromService, "from_service", false, "Search auth cache entries of the running service, over the admin socket")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s [-config FILE] [options] FILTER [ATTRIBUTE...]\n", os.Args[0], TestSearchCommand)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	if flagSet.NArg() < 1 {
+		flagSet.Usage()
+		os.Exit(2)
+	}
+
+	config := loadTestConfig(configFlags)
+	if len(baseDN) == 0 {
+		baseDN = config.LDAPBaseDN
+	}
+
+	cacheEntries := []ldap.AuthCacheEntry{}
+	if fromService {
+		if len(config.AdminSocketPath) == 0 {
+			fmt.Fprintln(os.Stderr, "admin socket is not configured")
+			os.Exit(1)
+		}
+
+		entries, err := admin.NewAdminClient(config.AdminSocketPath).ListCacheEntries()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list auth cache entries of the service - %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, entry := range entries {
+			cacheEntries = append(cacheEntries, ldap.AuthCacheEntry{
+				DN:       entry.DN,
+				Username: entry.Username,
+				Zone:     entry.Zone,
+				Groups:   entry.Groups,
+			})
+		}
+	}
+
+	svc, err := ldap.NewLDAP(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the service - %v\n", err)
+		os.Exit(1)
+	}
+	defer svc.Destroy()
+
+	userEntries, err := svc.TestSearch(baseDN, flagSet.Arg(0), flagSet.Args()[1:], cacheEntries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to search - %v\n", err)
+		svc.Destroy()
+		os.Exit(1)
+	}
+
+	// LDIF
+	for _, userEntry := range userEntries {
+		fmt.Printf("dn: %s\n", userEntry.DN)
+		for _, attribute := range userEntry.Attributes {
+			fmt.Printf("%s: %s\n", attribute.Name, attribute.Value)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("# %d entries\n", len(userEntries))
+}
+
+// loadTestConfig loads and validates the config for test subcommands, exits if it is invalid.
+// The persisted auth cache, background revalidation, grace mode and LDAPS of the service are not used
+func loadTestConfig(configFlags *commandConfigFlags) *commons.Config {
+	config, err := configFlags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config cannot be loaded - %v\n", err)
+		os.Exit(1)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		printConfigProblems(err)
+		os.Exit(1)
+	}
+
+	setLogLevel(config.LogLevel)
+
+	config.AuthCachePersistPath = ""
+	config.AuthRevalidationInterval = 0
+	config.AuthGracePeriod = 0
+	config.ServiceTLSPort = 0
+	return config
+}
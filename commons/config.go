@@ -1,7 +1,12 @@
 package commons
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/kelseyhightower/envconfig"
 	yaml "gopkg.in/yaml.v2"
@@ -10,112 +15,528 @@ import (
 const (
 	ServiceHostDefault      string = ""
 	ServicePortDefault      int    = 1389
+	ServiceTLSPortDefault   int    = 0 // disabled
 	IRODSPortDefault        int    = 1247
 	IRODSUserGroupDefault   string = ""
 	AuthCacheTimeoutDefault int    = 60 * 5 // 5min
 	LDAPBaseDNDefault       string = "dc=iplantcollaborative,dc=org"
 	LogFilePathDefault      string = "/tmp/ldap-irods-auth.log"
+	LogLevelDefault         string = "info"
+	AdminSocketPathDefault  string = "" // disabled
+	PIDFilePathDefault      string = "/tmp/ldap-irods-auth.pid"
+
+	IRODSConnectTimeoutDefault     int    = 10 // 10sec
+	IRODSOperationTimeoutDefault   int    = 30 // 30sec
+	IRODSApplicationNameDefault    string = "ldap-irods-auth"
+	IRODSTCPKeepAliveDefault       bool   = true
+	IRODSTCPKeepAlivePeriodDefault int    = 15 // 15sec
+
+	IRODSPoolMaxConnectionsDefault      int = 10
+	IRODSPoolIdleTimeoutDefault         int = 60 * 5 // 5min
+	IRODSPoolHealthCheckIntervalDefault int = 60     // 1min
+
+	AuthRevalidationIntervalDefault int = 60 * 10 // 10min
+
+	AuthCacheMaxLifetimeDefault     int  = 60 * 60 // 1hour
+	AuthCacheSlidingDefault         bool = false
+	AuthCacheMaxEntriesDefault      int  = 10000
+	AuthCacheCleanupIntervalDefault int  = 60 // 1min
+
+	AuthGracePeriodDefault int = 0 // disabled
+
+	AuthDisabledAVUValueDefault string = "true"
+
+	LDAPUsernameTrimDefault     bool = true
+	LDAPUsernameNFCDefault      bool = true
+	LDAPUsernameCaseFoldDefault bool = false
+
+	LDAPUsernameEmailCacheTimeoutDefault         int = 60 * 5 // 5min
+	LDAPUsernameEmailNegativeCacheTimeoutDefault int = 30     // 30sec
+
+	AuthCacheKDFTimeDefault        int = 1
+	AuthCacheKDFMemoryDefault      int = 64 * 1024 // 64MiB, in KiB
+	AuthCacheKDFThreadsDefault     int = 1
+	AuthCacheKDFConcurrencyDefault int = 2
+
+	// AuthCacheKDFMemoryMin is the least KDF memory accepted, so verifiers stay memory-hard
+	AuthCacheKDFMemoryMin int = 19 * 1024 // 19MiB, in KiB
+
+	AuthNegativeCacheTimeoutDefault int = 30      // 30sec
+	AuthFailureWindowDefault        int = 60 * 10 // 10min
+	AuthLockoutThresholdDNDefault   int = 5
+	AuthLockoutThresholdIPDefault   int = 20
+	AuthLockoutBaseDefault          int = 30      // 30sec
+	AuthLockoutMaxDefault           int = 60 * 15 // 15min
+
+	RateLimitIPRateDefault     float64 = 20
+	RateLimitIPBurstDefault    int     = 40
+	RateLimitDNRateDefault     float64 = 5
+	RateLimitDNBurstDefault    int     = 10
+	MaxConnectionsDefault      int     = 1000
+	MaxConnectionsPerIPDefault int     = 100
+
+	ShutdownDrainTimeoutDefault int  = 30 // 30sec
+	WatchdogRequireIRODSDefault bool = true
 )
 
+// IRODSZoneConfig is an entry of the federated zone table
+type IRODSZoneConfig struct {
+	Zone string `yaml:"zone"`
+	Host string `yaml:"host"`
+	Port int    `yaml:"port,omitempty"` // IRODSPortDefault if not given
+}
+
+// IRODSZoneTable is a list of federated zones, given as "zone=host:port,..." in env
+type IRODSZoneTable []IRODSZoneConfig
+
+// Decode parses the zone table from env
+func (table *IRODSZoneTable) Decode(value string) error {
+	zones := IRODSZoneTable{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid zone table entry %q, zone=host:port is expected", item)
+		}
+
+		zone := IRODSZoneConfig{
+			Zone: strings.TrimSpace(kv[0]),
+			Host: strings.TrimSpace(kv[1]),
+			Port: IRODSPortDefault,
+		}
+
+		if idx := strings.LastIndex(zone.Host, ":"); idx >= 0 {
+			port, err := strconv.Atoi(zone.Host[idx+1:])
+			if err != nil {
+				return fmt.Errorf("invalid port in zone table entry %q - %v", item, err)
+			}
+			zone.Host = zone.Host[:idx]
+			zone.Port = port
+		}
+
+		zones = append(zones, zone)
+	}
+
+	*table = zones
+	return nil
+}
+
+// NamingContextConfig maps an LDAP suffix to an iRODS backend
+type NamingContextConfig struct {
+	BaseDN string `yaml:"base_dn"`
+
+	IRODSHost      string         `yaml:"irods_host"`
+	IRODSPort      int            `yaml:"irods_port,omitempty"` // IRODSPortDefault if not given
+	IRODSZone      string         `yaml:"irods_zone"`
+	IRODSUserGroup string         `yaml:"irods_user_group,omitempty"`
+	IRODSZones     IRODSZoneTable `yaml:"irods_zones,omitempty"`
+
+	IRODSAdminUsername string `yaml:"irods_admin_username,omitempty"`
+	IRODSAdminPassword string `yaml:"irods_admin_password,omitempty"`
+	// file containing the password, instead of irods_admin_password
+	IRODSAdminPasswordFile string `yaml:"irods_admin_password_file,omitempty"`
+
+	// LDAPAttributes of the top level config are used if not given
+	LDAPAttributes map[string]string `yaml:"ldap_attributes,omitempty"`
+}
+
 // Config holds the parameters list which can be configured
 type Config struct {
 	ServiceHost string `envconfig:"LDAP_IRODS_AUTH_SERVICE_HOST" yaml:"service_host"`
 	ServicePort int    `envconfig:"LDAP_IRODS_AUTH_SERVICE_PORT" yaml:"service_port"`
 
+	// LDAPS, disabled if the port is 0. Certificates are re-read on reload
+	ServiceTLSPort int    `envconfig:"LDAP_IRODS_AUTH_SERVICE_TLS_PORT" yaml:"service_tls_port"`
+	TLSCertPath    string `envconfig:"LDAP_IRODS_AUTH_TLS_CERT_PATH" yaml:"tls_cert_path,omitempty"`
+	TLSKeyPath     string `envconfig:"LDAP_IRODS_AUTH_TLS_KEY_PATH" yaml:"tls_key_path,omitempty"`
+	// PEM of the key, e.g. from a secret env var, instead of tls_key_path. It is read from tls_key_path on load
+	TLSKey string `envconfig:"LDAP_IRODS_AUTH_TLS_KEY" yaml:"tls_key,omitempty"`
+
 	IRODSHost      string `envconfig:"LDAP_IRODS_AUTH_IRODS_HOST" yaml:"irods_host"`
 	IRODSPort      int    `envconfig:"LDAP_IRODS_AUTH_IRODS_PORT" yaml:"irods_port"`
 	IRODSZone      string `envconfig:"LDAP_IRODS_AUTH_IRODS_ZONE" yaml:"irods_zone"`
 	IRODSUserGroup string `envconfig:"LDAP_IRODS_AUTH_IRODS_USER_GROUP" yaml:"irods_user_group"`
 
-	AuthCacheTimeout int `envconfig:"LDAP_IRODS_AUTH_CACHE_TIMEOUT" yaml:"auth_cache_timeout"`
+	// federated zones, users of these zones authenticate against their own hosts
+	IRODSZones IRODSZoneTable `envconfig:"LDAP_IRODS_AUTH_IRODS_ZONES" yaml:"irods_zones,omitempty"`
+
+	// service account for catalog queries
+	IRODSAdminUsername string `envconfig:"LDAP_IRODS_AUTH_IRODS_ADMIN_USERNAME" yaml:"irods_admin_username,omitempty"`
+	IRODSAdminPassword string `envconfig:"LDAP_IRODS_AUTH_IRODS_ADMIN_PASSWORD" yaml:"irods_admin_password,omitempty"`
+	// file containing the password, e.g. a Docker or Kubernetes secret, instead of irods_admin_password
+	IRODSAdminPasswordFile string `envconfig:"LDAP_IRODS_AUTH_IRODS_ADMIN_PASSWORD_FILE" yaml:"irods_admin_password_file,omitempty"`
+
+	IRODSConnectTimeout     int    `envconfig:"LDAP_IRODS_AUTH_IRODS_CONNECT_TIMEOUT" yaml:"irods_connect_timeout"`
+	IRODSOperationTimeout   int    `envconfig:"LDAP_IRODS_AUTH_IRODS_OPERATION_TIMEOUT" yaml:"irods_operation_timeout"`
+	IRODSApplicationName    string `envconfig:"LDAP_IRODS_AUTH_IRODS_APPLICATION_NAME" yaml:"irods_application_name"`
+	IRODSTCPKeepAlive       bool   `envconfig:"LDAP_IRODS_AUTH_IRODS_TCP_KEEPALIVE" yaml:"irods_tcp_keepalive"`
+	IRODSTCPKeepAlivePeriod int    `envconfig:"LDAP_IRODS_AUTH_IRODS_TCP_KEEPALIVE_PERIOD" yaml:"irods_tcp_keepalive_period"`
+
+	// pool of service account connections for catalog queries
+	IRODSPoolMaxConnections      int `envconfig:"LDAP_IRODS_AUTH_IRODS_POOL_MAX_CONNECTIONS" yaml:"irods_pool_max_connections"`
+	IRODSPoolIdleTimeout         int `envconfig:"LDAP_IRODS_AUTH_IRODS_POOL_IDLE_TIMEOUT" yaml:"irods_pool_idle_timeout"`
+	IRODSPoolHealthCheckInterval int `envconfig:"LDAP_IRODS_AUTH_IRODS_POOL_HEALTH_CHECK_INTERVAL" yaml:"irods_pool_health_check_interval"`
+
+	AuthCacheTimeout         int  `envconfig:"LDAP_IRODS_AUTH_CACHE_TIMEOUT" yaml:"auth_cache_timeout"`
+	AuthCacheMaxLifetime     int  `envconfig:"LDAP_IRODS_AUTH_CACHE_MAX_LIFETIME" yaml:"auth_cache_max_lifetime"`
+	AuthCacheSliding         bool `envconfig:"LDAP_IRODS_AUTH_CACHE_SLIDING" yaml:"auth_cache_sliding"`
+	AuthCacheMaxEntries      int  `envconfig:"LDAP_IRODS_AUTH_CACHE_MAX_ENTRIES" yaml:"auth_cache_max_entries"`
+	AuthCacheCleanupInterval int  `envconfig:"LDAP_IRODS_AUTH_CACHE_CLEANUP_INTERVAL" yaml:"auth_cache_cleanup_interval"`
+
+	// interval of revalidating cached users against iRODS, requires the service account of the naming context.
+	// Users of other zones cannot be checked by the service account and are kept until the cache expires them
+	AuthRevalidationInterval int `envconfig:"LDAP_IRODS_AUTH_REVALIDATION_INTERVAL" yaml:"auth_revalidation_interval"`
+
+	// period since the last verification in which cached credentials are accepted while iRODS is unavailable
+	AuthGracePeriod int `envconfig:"LDAP_IRODS_AUTH_GRACE_PERIOD" yaml:"auth_grace_period"`
+
+	// persistent auth cache, disabled if the path is empty
+	AuthCachePersistPath string `envconfig:"LDAP_IRODS_AUTH_CACHE_PERSIST_PATH" yaml:"auth_cache_persist_path,omitempty"`
+	AuthCacheKeyFilePath string `envconfig:"LDAP_IRODS_AUTH_CACHE_KEY_FILE" yaml:"auth_cache_key_file,omitempty"`
+
+	// argon2id cost of verifiers persisted with the auth cache, memory is in KiB.
+	// Concurrency caps argon2id computations running at once, each takes the memory given
+	AuthCacheKDFTime        int `envconfig:"LDAP_IRODS_AUTH_CACHE_KDF_TIME" yaml:"auth_cache_kdf_time"`
+	AuthCacheKDFMemory      int `envconfig:"LDAP_IRODS_AUTH_CACHE_KDF_MEMORY" yaml:"auth_cache_kdf_memory"`
+	AuthCacheKDFThreads     int `envconfig:"LDAP_IRODS_AUTH_CACHE_KDF_THREADS" yaml:"auth_cache_kdf_threads"`
+	AuthCacheKDFConcurrency int `envconfig:"LDAP_IRODS_AUTH_CACHE_KDF_CONCURRENCY" yaml:"auth_cache_kdf_concurrency"`
+
+	// user policy, empty lists do not restrict
+	AuthAllowedUserTypes []string `envconfig:"LDAP_IRODS_AUTH_ALLOWED_USER_TYPES" yaml:"auth_allowed_user_types,omitempty"`
+	AuthDeniedUserTypes  []string `envconfig:"LDAP_IRODS_AUTH_DENIED_USER_TYPES" yaml:"auth_denied_user_types,omitempty"`
+	AuthAllowedZones     []string `envconfig:"LDAP_IRODS_AUTH_ALLOWED_ZONES" yaml:"auth_allowed_zones,omitempty"`
+	AuthDeniedZones      []string `envconfig:"LDAP_IRODS_AUTH_DENIED_ZONES" yaml:"auth_denied_zones,omitempty"`
+	AuthDisabledAVUName  string   `envconfig:"LDAP_IRODS_AUTH_DISABLED_AVU_NAME" yaml:"auth_disabled_avu_name,omitempty"`
+	AuthDisabledAVUValue string   `envconfig:"LDAP_IRODS_AUTH_DISABLED_AVU_VALUE" yaml:"auth_disabled_avu_value"`
+
+	AuthNegativeCacheTimeout int `envconfig:"LDAP_IRODS_AUTH_NEGATIVE_CACHE_TIMEOUT" yaml:"auth_negative_cache_timeout"`
+	AuthFailureWindow        int `envconfig:"LDAP_IRODS_AUTH_FAILURE_WINDOW" yaml:"auth_failure_window"`
+	AuthLockoutThresholdDN   int `envconfig:"LDAP_IRODS_AUTH_LOCKOUT_THRESHOLD_DN" yaml:"auth_lockout_threshold_dn"`
+	AuthLockoutThresholdIP   int `envconfig:"LDAP_IRODS_AUTH_LOCKOUT_THRESHOLD_IP" yaml:"auth_lockout_threshold_ip"`
+	AuthLockoutBase          int `envconfig:"LDAP_IRODS_AUTH_LOCKOUT_BASE" yaml:"auth_lockout_base"`
+	AuthLockoutMax           int `envconfig:"LDAP_IRODS_AUTH_LOCKOUT_MAX" yaml:"auth_lockout_max"`
+
+	RateLimitIPRate     float64 `envconfig:"LDAP_IRODS_AUTH_RATE_LIMIT_IP_RATE" yaml:"rate_limit_ip_rate"`
+	RateLimitIPBurst    int     `envconfig:"LDAP_IRODS_AUTH_RATE_LIMIT_IP_BURST" yaml:"rate_limit_ip_burst"`
+	RateLimitDNRate     float64 `envconfig:"LDAP_IRODS_AUTH_RATE_LIMIT_DN_RATE" yaml:"rate_limit_dn_rate"`
+	RateLimitDNBurst    int     `envconfig:"LDAP_IRODS_AUTH_RATE_LIMIT_DN_BURST" yaml:"rate_limit_dn_burst"`
+	MaxConnections      int     `envconfig:"LDAP_IRODS_AUTH_MAX_CONNECTIONS" yaml:"max_connections"`
+	MaxConnectionsPerIP int     `envconfig:"LDAP_IRODS_AUTH_MAX_CONNECTIONS_PER_IP" yaml:"max_connections_per_ip"`
+
+	// seconds to let in-flight operations finish on shutdown, 0 closes connections immediately
+	ShutdownDrainTimeout int `envconfig:"LDAP_IRODS_AUTH_SHUTDOWN_DRAIN_TIMEOUT" yaml:"shutdown_drain_timeout"`
+
+	// the systemd watchdog is notified only while iRODS is reachable, so systemd restarts the service on iRODS outages.
+	// If disabled, iRODS reachability is reported in the status only and the service keeps serving in grace mode
+	WatchdogRequireIRODS bool `envconfig:"LDAP_IRODS_AUTH_WATCHDOG_REQUIRE_IRODS" yaml:"watchdog_require_irods"`
 
 	LDAPBaseDN string `envconfig:"LDAP_IRODS_AUTH_LDAP_BASE_DN" yaml:"ldap_base_dn"`
 
-	LogPath string `envconfig:"LDAP_IRODS_AUTH_LOG_PATH" yaml:"log_path,omitempty"`
+	// bind name formats accepted, in order: uid, cn, upn (user@zone), bare and regex
+	LDAPBindNameResolvers []string `envconfig:"LDAP_IRODS_AUTH_LDAP_BIND_NAME_RESOLVERS" yaml:"ldap_bind_name_resolvers"`
+	// regular expression with a "user" group and an optional "zone" group, for the regex resolver
+	LDAPBindNameRegex string `envconfig:"LDAP_IRODS_AUTH_LDAP_BIND_NAME_REGEX" yaml:"ldap_bind_name_regex,omitempty"`
+
+	// username normalization applied to bind names and searched uids
+	LDAPUsernameTrim     bool              `envconfig:"LDAP_IRODS_AUTH_LDAP_USERNAME_TRIM" yaml:"ldap_username_trim"`
+	LDAPUsernameNFC      bool              `envconfig:"LDAP_IRODS_AUTH_LDAP_USERNAME_NFC" yaml:"ldap_username_nfc"`
+	LDAPUsernameCaseFold bool              `envconfig:"LDAP_IRODS_AUTH_LDAP_USERNAME_CASE_FOLD" yaml:"ldap_username_case_fold"`
+	LDAPUsernameAliases  map[string]string `envconfig:"LDAP_IRODS_AUTH_LDAP_USERNAME_ALIASES" yaml:"ldap_username_aliases,omitempty"` // alias to "user" or "user#zone"
+	// AVU name holding emails of iRODS users, to accept emails as usernames. Requires the service account
+	LDAPUsernameEmailAVU string `envconfig:"LDAP_IRODS_AUTH_LDAP_USERNAME_EMAIL_AVU" yaml:"ldap_username_email_avu,omitempty"`
+	// seconds a username looked up by email is cached, and an email not matching exactly one user
+	LDAPUsernameEmailCacheTimeout         int `envconfig:"LDAP_IRODS_AUTH_LDAP_USERNAME_EMAIL_CACHE_TIMEOUT" yaml:"ldap_username_email_cache_timeout"`
+	LDAPUsernameEmailNegativeCacheTimeout int `envconfig:"LDAP_IRODS_AUTH_LDAP_USERNAME_EMAIL_NEGATIVE_CACHE_TIMEOUT" yaml:"ldap_username_email_negative_cache_timeout"`
+
+	// attributes of user entries, values are templates with {uid}, {username} and {zone}.
+	// NewDefaultLDAPAttributes is used if not given
+	LDAPAttributes map[string]string `envconfig:"LDAP_IRODS_AUTH_LDAP_ATTRIBUTES" yaml:"ldap_attributes,omitempty"`
+
+	// suffixes served with their own iRODS backends, the top level base DN and iRODS backend are used if empty
+	NamingContexts []NamingContextConfig `ignored:"true" yaml:"naming_contexts,omitempty"`
+
+	LogPath  string `envconfig:"LDAP_IRODS_AUTH_LOG_PATH" yaml:"log_path,omitempty"`
+	LogLevel string `envconfig:"LDAP_IRODS_AUTH_LOG_LEVEL" yaml:"log_level"`
+
+	// admin API, disabled if the path is empty
+	AdminSocketPath string `envconfig:"LDAP_IRODS_AUTH_ADMIN_SOCKET_PATH" yaml:"admin_socket_path"`
+
+	// locked while the service runs, for status and stop commands. disabled if the path is empty
+	PIDFilePath string `envconfig:"LDAP_IRODS_AUTH_PID_FILE_PATH" yaml:"pid_file_path"`
 
 	Foreground   bool `yaml:"foreground,omitempty"`
-	ChildProcess bool `yaml:"childprocess,omitempty"`
+	ChildProcess bool `flag:"-" yaml:"childprocess,omitempty"`
+
+	// sources the config is loaded from, to load it again on reload
+	ConfigFilePath string      `ignored:"true" flag:"-" yaml:"config_file_path,omitempty"`
+	ConfigFlags    ConfigFlags `ignored:"true" flag:"-" yaml:"config_flags,omitempty"`
 }
 
 // NewDefaultConfig creates DefaultConfig
 func NewDefaultConfig() *Config {
 	return &Config{
-		ServiceHost:      ServiceHostDefault,
-		ServicePort:      ServicePortDefault,
-		IRODSPort:        IRODSPortDefault,
-		IRODSUserGroup:   IRODSUserGroupDefault,
-		AuthCacheTimeout: AuthCacheTimeoutDefault,
-		LDAPBaseDN:       LDAPBaseDNDefault,
-		LogPath:          LogFilePathDefault,
+		ServiceHost:    ServiceHostDefault,
+		ServicePort:    ServicePortDefault,
+		ServiceTLSPort: ServiceTLSPortDefault,
+		IRODSPort:      IRODSPortDefault,
+		IRODSUserGroup: IRODSUserGroupDefault,
+
+		IRODSConnectTimeout:     IRODSConnectTimeoutDefault,
+		IRODSOperationTimeout:   IRODSOperationTimeoutDefault,
+		IRODSApplicationName:    IRODSApplicationNameDefault,
+		IRODSTCPKeepAlive:       IRODSTCPKeepAliveDefault,
+		IRODSTCPKeepAlivePeriod: IRODSTCPKeepAlivePeriodDefault,
+
+		IRODSPoolMaxConnections:      IRODSPoolMaxConnectionsDefault,
+		IRODSPoolIdleTimeout:         IRODSPoolIdleTimeoutDefault,
+		IRODSPoolHealthCheckInterval: IRODSPoolHealthCheckIntervalDefault,
+
+		AuthCacheTimeout:         AuthCacheTimeoutDefault,
+		AuthCacheMaxLifetime:     AuthCacheMaxLifetimeDefault,
+		AuthCacheSliding:         AuthCacheSlidingDefault,
+		AuthCacheMaxEntries:      AuthCacheMaxEntriesDefault,
+		AuthCacheCleanupInterval: AuthCacheCleanupIntervalDefault,
+
+		AuthRevalidationInterval: AuthRevalidationIntervalDefault,
+		AuthGracePeriod:          AuthGracePeriodDefault,
+
+		AuthDisabledAVUValue: AuthDisabledAVUValueDefault,
+
+		AuthCacheKDFTime:        AuthCacheKDFTimeDefault,
+		AuthCacheKDFMemory:      AuthCacheKDFMemoryDefault,
+		AuthCacheKDFThreads:     AuthCacheKDFThreadsDefault,
+		AuthCacheKDFConcurrency: AuthCacheKDFConcurrencyDefault,
+
+		AuthNegativeCacheTimeout: AuthNegativeCacheTimeoutDefault,
+		AuthFailureWindow:        AuthFailureWindowDefault,
+		AuthLockoutThresholdDN:   AuthLockoutThresholdDNDefault,
+		AuthLockoutThresholdIP:   AuthLockoutThresholdIPDefault,
+		AuthLockoutBase:          AuthLockoutBaseDefault,
+		AuthLockoutMax:           AuthLockoutMaxDefault,
+
+		RateLimitIPRate:     RateLimitIPRateDefault,
+		RateLimitIPBurst:    RateLimitIPBurstDefault,
+		RateLimitDNRate:     RateLimitDNRateDefault,
+		RateLimitDNBurst:    RateLimitDNBurstDefault,
+		MaxConnections:      MaxConnectionsDefault,
+		MaxConnectionsPerIP: MaxConnectionsPerIPDefault,
+
+		ShutdownDrainTimeout: ShutdownDrainTimeoutDefault,
+		WatchdogRequireIRODS: WatchdogRequireIRODSDefault,
+
+		LDAPBaseDN:            LDAPBaseDNDefault,
+		LDAPBindNameResolvers: []string{"uid"},
+		LDAPUsernameTrim:      LDAPUsernameTrimDefault,
+		LDAPUsernameNFC:       LDAPUsernameNFCDefault,
+		LDAPUsernameCaseFold:  LDAPUsernameCaseFoldDefault,
+
+		LDAPUsernameEmailCacheTimeout:         LDAPUsernameEmailCacheTimeoutDefault,
+		LDAPUsernameEmailNegativeCacheTimeout: LDAPUsernameEmailNegativeCacheTimeoutDefault,
+
+		LogPath:  LogFilePathDefault,
+		LogLevel: LogLevelDefault,
+
+		AdminSocketPath: AdminSocketPathDefault,
+		PIDFilePath:     PIDFilePathDefault,
 
 		Foreground:   false,
 		ChildProcess: false,
 	}
 }
 
-// NewConfigFromENV creates Config from Environmental Variables
+// NewDefaultLDAPAttributes returns attributes of user entries served by default
+func NewDefaultLDAPAttributes() map[string]string {
+	return map[string]string{
+		"uid":  "{uid}",
+		"cn":   "{username}",
+		"mail": "{username}@cyverse.org",
+		"zone": "{zone}",
+	}
+}
+
+// NewConfigFromENV creates Config from Environmental Variables, resolving _file secrets
 func NewConfigFromENV() (*Config, error) {
-	config := Config{
-		ServiceHost:      ServiceHostDefault,
-		ServicePort:      ServicePortDefault,
-		IRODSPort:        IRODSPortDefault,
-		IRODSUserGroup:   IRODSUserGroupDefault,
-		AuthCacheTimeout: AuthCacheTimeoutDefault,
-		LDAPBaseDN:       LDAPBaseDNDefault,
-		LogPath:          LogFilePathDefault,
+	config := NewDefaultConfig()
 
-		Foreground:   false,
-		ChildProcess: false,
+	err := envconfig.Process("", config)
+	if err != nil {
+		return nil, fmt.Errorf("Env Read Error - %v", err)
 	}
 
-	err := envconfig.Process("", &config)
+	err = config.ResolveSecrets()
 	if err != nil {
-		return nil, fmt.Errorf("Env Read Error - %v", err)
+		return nil, err
 	}
 
-	return &config, nil
+	return config, nil
 }
 
-// NewConfigFromYAML creates Config from YAML
+// NewConfigFromYAML creates Config from YAML, resolving ${ENV} references and _file secrets.
+// Unknown keys are errors
 func NewConfigFromYAML(yamlBytes []byte) (*Config, error) {
-	config := Config{
-		ServiceHost:      ServiceHostDefault,
-		ServicePort:      ServicePortDefault,
-		IRODSPort:        IRODSPortDefault,
-		IRODSUserGroup:   IRODSUserGroupDefault,
-		AuthCacheTimeout: AuthCacheTimeoutDefault,
-		LDAPBaseDN:       LDAPBaseDNDefault,
-		LogPath:          LogFilePathDefault,
+	config := NewDefaultConfig()
 
-		Foreground:   false,
-		ChildProcess: false,
+	err := yaml.UnmarshalStrict(yamlBytes, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML - %v", err)
 	}
 
-	err := yaml.Unmarshal(yamlBytes, &config)
+	err = interpolateEnv(reflect.ValueOf(config).Elem())
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal YAML - %v", err)
+		return nil, err
+	}
+
+	err = config.ResolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// GetNamingContexts returns a config per naming context, with the base DN and iRODS backend of the context.
+// The config itself is returned if no naming context is given
+func (config *Config) GetNamingContexts() []*Config {
+	if len(config.NamingContexts) == 0 {
+		return []*Config{config}
+	}
+
+	configs := []*Config{}
+	for _, namingContext := range config.NamingContexts {
+		contextConfig := *config
+		contextConfig.NamingContexts = nil
+
+		contextConfig.LDAPBaseDN = namingContext.BaseDN
+		contextConfig.IRODSHost = namingContext.IRODSHost
+		contextConfig.IRODSPort = namingContext.IRODSPort
+		if contextConfig.IRODSPort == 0 {
+			contextConfig.IRODSPort = IRODSPortDefault
+		}
+		contextConfig.IRODSZone = namingContext.IRODSZone
+		contextConfig.IRODSUserGroup = namingContext.IRODSUserGroup
+		contextConfig.IRODSZones = namingContext.IRODSZones
+		contextConfig.IRODSAdminUsername = namingContext.IRODSAdminUsername
+		contextConfig.IRODSAdminPassword = namingContext.IRODSAdminPassword
+		contextConfig.IRODSAdminPasswordFile = namingContext.IRODSAdminPasswordFile
+
+		if len(namingContext.LDAPAttributes) > 0 {
+			contextConfig.LDAPAttributes = namingContext.LDAPAttributes
+		}
+
+		configs = append(configs, &contextConfig)
 	}
+	return configs
+}
 
-	return &config, nil
+// HasIRODSServiceAccount checks if the service account for catalog queries is given
+func (config *Config) HasIRODSServiceAccount() bool {
+	return len(config.IRODSAdminUsername) > 0 && len(config.IRODSAdminPassword) > 0
 }
 
-// Validate validates configuration
-func (config *Config) Validate() error {
-	if config.ServicePort <= 0 {
-		return fmt.Errorf("Service port must be given")
+// LoadTLSCertificate loads the TLS certificate file with the key given or read from the key file
+func (config *Config) LoadTLSCertificate() (tls.Certificate, error) {
+	if len(config.TLSKey) == 0 {
+		return tls.LoadX509KeyPair(config.TLSCertPath, config.TLSKeyPath)
 	}
 
-	if len(config.IRODSHost) == 0 {
-		return fmt.Errorf("IRODS hostname must be given")
+	certBytes, err := ioutil.ReadFile(config.TLSCertPath)
+	if err != nil {
+		return tls.Certificate{}, err
 	}
+	return tls.X509KeyPair(certBytes, []byte(config.TLSKey))
+}
 
-	if config.IRODSPort <= 0 {
-		return fmt.Errorf("IRODS port must be given")
+// RequiresRestart checks if the new config changes settings that are not applied on reload.
+// Reloadable settings are the user policy, attribute maps, auth cache TTLs, email cache TTLs,
+// failure lockouts, rate limits, log level and TLS certificates
+func (config *Config) RequiresRestart(newConfig *Config) bool {
+	oldConfig := *config
+	oldConfig.clearReloadable()
+
+	compared := *newConfig
+	compared.clearReloadable()
+
+	return !reflect.DeepEqual(&oldConfig, &compared)
+}
+
+// MergeReloadable returns a copy of the config with reloadable settings of the new config,
+// settings requiring a restart are kept as they are in effect
+func (config *Config) MergeReloadable(newConfig *Config) *Config {
+	merged := *config
+	merged.copyReloadable(newConfig)
+	return &merged
+}
+
+// clearReloadable clears settings applied on reload and those of the process
+func (config *Config) clearReloadable() {
+	empty := &Config{}
+	for _, namingContext := range config.NamingContexts {
+		empty.NamingContexts = append(empty.NamingContexts, NamingContextConfig{
+			BaseDN: namingContext.BaseDN,
+		})
 	}
+	config.copyReloadable(empty)
+
+	config.Foreground = false
+	config.ChildProcess = false
+	config.ConfigFilePath = ""
+	config.ConfigFlags = nil
+}
+
+// copyReloadable copies settings applied on reload from the source config.
+// Settings of naming contexts are copied from those of the same base DN
+func (config *Config) copyReloadable(source *Config) {
+	config.IRODSUserGroup = source.IRODSUserGroup
+	config.AuthAllowedUserTypes = source.AuthAllowedUserTypes
+	config.AuthDeniedUserTypes = source.AuthDeniedUserTypes
+	config.AuthAllowedZones = source.AuthAllowedZones
+	config.AuthDeniedZones = source.AuthDeniedZones
+	config.AuthDisabledAVUName = source.AuthDisabledAVUName
+	config.AuthDisabledAVUValue = source.AuthDisabledAVUValue
+
+	config.AuthCacheTimeout = source.AuthCacheTimeout
+	config.AuthCacheMaxLifetime = source.AuthCacheMaxLifetime
+	config.AuthCacheSliding = source.AuthCacheSliding
+	config.AuthCacheMaxEntries = source.AuthCacheMaxEntries
+	config.AuthGracePeriod = source.AuthGracePeriod
 
-	if len(config.IRODSZone) == 0 {
-		return fmt.Errorf("IRODS zone must be given")
+	config.LDAPUsernameEmailCacheTimeout = source.LDAPUsernameEmailCacheTimeout
+	config.LDAPUsernameEmailNegativeCacheTimeout = source.LDAPUsernameEmailNegativeCacheTimeout
+
+	config.AuthNegativeCacheTimeout = source.AuthNegativeCacheTimeout
+	config.AuthFailureWindow = source.AuthFailureWindow
+	config.AuthLockoutThresholdDN = source.AuthLockoutThresholdDN
+	config.AuthLockoutThresholdIP = source.AuthLockoutThresholdIP
+	config.AuthLockoutBase = source.AuthLockoutBase
+	config.AuthLockoutMax = source.AuthLockoutMax
+
+	config.RateLimitIPRate = source.RateLimitIPRate
+	config.RateLimitIPBurst = source.RateLimitIPBurst
+	config.RateLimitDNRate = source.RateLimitDNRate
+	config.RateLimitDNBurst = source.RateLimitDNBurst
+
+	config.LDAPAttributes = source.LDAPAttributes
+	config.TLSCertPath = source.TLSCertPath
+	config.TLSKeyPath = source.TLSKeyPath
+	config.TLSKey = source.TLSKey
+	config.LogLevel = source.LogLevel
+
+	sourceNamingContexts := map[string]NamingContextConfig{}
+	for _, namingContext := range source.NamingContexts {
+		sourceNamingContexts[namingContext.BaseDN] = namingContext
 	}
 
-	return nil
+	namingContexts := make([]NamingContextConfig, len(config.NamingContexts))
+	for i, namingContext := range config.NamingContexts {
+		if sourceNamingContext, ok := sourceNamingContexts[namingContext.BaseDN]; ok {
+			namingContext.IRODSUserGroup = sourceNamingContext.IRODSUserGroup
+			namingContext.LDAPAttributes = sourceNamingContext.LDAPAttributes
+		}
+		namingContexts[i] = namingContext
+	}
+	config.NamingContexts = namingContexts
 }
@@ -0,0 +1,278 @@
+package commons
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFlags holds config values given as command-line flags, keyed by YAML key
+type ConfigFlags map[string]string
+
+// configFlagValue is a flag.Value collecting the value of a config field
+type configFlagValue struct {
+	key          string
+	flags        ConfigFlags
+	defaultValue string
+	isBool       bool
+}
+
+// String returns the default value, for usage
+func (value *configFlagValue) String() string {
+	if value == nil {
+		return ""
+	}
+	return value.defaultValue
+}
+
+// Set validates and collects the value
+func (value *configFlagValue) Set(flagValue string) error {
+	err := NewDefaultConfig().setField(value.key, flagValue)
+	if err != nil {
+		return err
+	}
+
+	value.flags[value.key] = flagValue
+	return nil
+}
+
+// IsBoolFlag allows boolean flags without a value
+func (value *configFlagValue) IsBoolFlag() bool {
+	return value.isBool
+}
+
+// AddConfigFlags registers a flag per config field on the flag set, named by its YAML key.
+// Values given are collected in flags, to be applied by LoadConfig
+func AddConfigFlags(flagSet *flag.FlagSet, flags ConfigFlags) {
+	defaultConfig := reflect.ValueOf(NewDefaultConfig()).Elem()
+	configType := defaultConfig.Type()
+
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		key := getConfigFieldKey(field)
+		if len(key) == 0 {
+			continue
+		}
+
+		usage := fmt.Sprintf("Set %s", key)
+		if envName, ok := field.Tag.Lookup("envconfig"); ok {
+			usage = fmt.Sprintf("Set %s, env %s", key, envName)
+		}
+
+		flagSet.Var(&configFlagValue{
+			key:          key,
+			flags:        flags,
+			defaultValue: formatConfigField(defaultConfig.Field(i)),
+			isBool:       field.Type.Kind() == reflect.Bool,
+		}, key, usage)
+	}
+}
+
+// AddConfigFlagAlias registers another flag name for the config field with the YAML key
+func AddConfigFlagAlias(flagSet *flag.FlagSet, flags ConfigFlags, alias string, key string, usage string) {
+	index, ok := getConfigFieldIndex(key)
+	if !ok {
+		panic(fmt.Sprintf("unknown config key %q", key))
+	}
+
+	defaultConfig := reflect.ValueOf(NewDefaultConfig()).Elem()
+	flagSet.Var(&configFlagValue{
+		key:          key,
+		flags:        flags,
+		defaultValue: formatConfigField(defaultConfig.Field(index)),
+		isBool:       defaultConfig.Field(index).Kind() == reflect.Bool,
+	}, alias, usage)
+}
+
+// LoadConfig loads config in the order of precedence: defaults, YAML file, env and flags, then resolves secrets.
+// ${ENV} references are replaced in values of the file only.
+// The file is not read if the path is empty, "-" reads STDIN. Unknown keys in the file are errors
+func LoadConfig(configFilePath string, flags ConfigFlags) (*Config, error) {
+	config := NewDefaultConfig()
+
+	if len(configFilePath) > 0 {
+		yamlBytes, err := readConfigFile(configFilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		err = yaml.UnmarshalStrict(yamlBytes, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML - %v", err)
+		}
+
+		// only values of the file are interpolated, env and flags are taken literally
+		err = interpolateEnv(reflect.ValueOf(config).Elem())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// unset env vars leave values untouched
+	err := envconfig.Process("", config)
+	if err != nil {
+		return nil, fmt.Errorf("Env Read Error - %v", err)
+	}
+
+	for key, value := range flags {
+		err := config.setField(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = config.ResolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	config.ConfigFilePath = configFilePath
+	config.ConfigFlags = flags
+	return config, nil
+}
+
+// readConfigFile reads the YAML file, "-" reads STDIN
+func readConfigFile(configFilePath string) ([]byte, error) {
+	if configFilePath == "-" {
+		yamlBytes, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read STDIN - %v", err)
+		}
+		return yamlBytes, nil
+	}
+
+	fileinfo, err := os.Stat(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access the local yaml file %s - %v", configFilePath, err)
+	}
+
+	if fileinfo.IsDir() {
+		return nil, fmt.Errorf("local yaml file %s is not a file", configFilePath)
+	}
+
+	yamlBytes, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the local yaml file %s - %v", configFilePath, err)
+	}
+	return yamlBytes, nil
+}
+
+// getConfigFieldKey returns the YAML key of the config field, empty if it cannot be set by flags
+func getConfigFieldKey(field reflect.StructField) string {
+	if field.Tag.Get("flag") == "-" {
+		return ""
+	}
+
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// getConfigFieldIndex returns the index of the config field with the YAML key
+func getConfigFieldIndex(key string) (int, bool) {
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		if getConfigFieldKey(configType.Field(i)) == key {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// setField sets the config field with the YAML key, values are in the env format.
+// Fields of other types are given in YAML
+func (config *Config) setField(key string, value string) error {
+	index, ok := getConfigFieldIndex(key)
+	if !ok {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+
+	err := setConfigField(reflect.ValueOf(config).Elem().Field(index), value)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s - %v", key, err)
+	}
+	return nil
+}
+
+// setConfigField parses the value into the field
+func setConfigField(field reflect.Value, value string) error {
+	if decoder, ok := field.Addr().Interface().(envconfig.Decoder); ok {
+		return decoder.Decode(value)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(intValue))
+	case reflect.Bool:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolValue)
+	case reflect.Float64:
+		floatValue, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(floatValue)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return yaml.UnmarshalStrict([]byte(value), field.Addr().Interface())
+		}
+
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		items := map[string]string{}
+		for _, item := range strings.Split(value, ",") {
+			if len(strings.TrimSpace(item)) == 0 {
+				continue
+			}
+
+			kv := strings.SplitN(item, ":", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("key:value is expected")
+			}
+			items[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return yaml.UnmarshalStrict([]byte(value), field.Addr().Interface())
+	}
+	return nil
+}
+
+// formatConfigField formats the value of the field for usage
+func formatConfigField(field reflect.Value) string {
+	switch field.Kind() {
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String {
+			return strings.Join(field.Interface().([]string), ",")
+		}
+		return ""
+	case reflect.Map:
+		return ""
+	default:
+		return fmt.Sprintf("%v", field.Interface())
+	}
+}
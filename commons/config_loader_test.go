@@ -0,0 +1,217 @@
+package commons
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestConfigFile writes a YAML config file in a temp dir
+func writeTestConfigFile(t *testing.T, dir string, yamlText string) string {
+	path := filepath.Join(dir, "config.yaml")
+	err := ioutil.WriteFile(path, []byte(yamlText), 0600)
+	if err != nil {
+		t.Fatalf("failed to write config file - %v", err)
+	}
+	return path
+}
+
+// setTestEnv sets env vars, returns a function restoring them
+func setTestEnv(t *testing.T, env map[string]string) func() {
+	oldEnv := map[string]*string{}
+	for name, value := range env {
+		if oldValue, ok := os.LookupEnv(name); ok {
+			oldEnv[name] = &oldValue
+		} else {
+			oldEnv[name] = nil
+		}
+
+		err := os.Setenv(name, value)
+		if err != nil {
+			t.Fatalf("failed to set env var %s - %v", name, err)
+		}
+	}
+
+	return func() {
+		for name, oldValue := range oldEnv {
+			if oldValue == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *oldValue)
+			}
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFilePath := writeTestConfigFile(t, dir, `
+service_port: 1000
+irods_host: yaml.example.org
+irods_port: 1000
+irods_zone: yamlZone
+`)
+
+	restoreEnv := setTestEnv(t, map[string]string{
+		"LDAP_IRODS_AUTH_SERVICE_PORT": "2000",
+		"LDAP_IRODS_AUTH_IRODS_PORT":   "2000",
+	})
+	defer restoreEnv()
+
+	config, err := LoadConfig(configFilePath, ConfigFlags{
+		"service_port": "3000",
+	})
+	if err != nil {
+		t.Fatalf("failed to load config - %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		actual   interface{}
+		expected interface{}
+	}{
+		{"default", config.LogLevel, LogLevelDefault},
+		{"YAML over default", config.IRODSHost, "yaml.example.org"},
+		{"YAML over default", config.IRODSZone, "yamlZone"},
+		{"env over YAML", config.IRODSPort, 2000},
+		{"flag over env", config.ServicePort, 3000},
+		{"config file path", config.ConfigFilePath, configFilePath},
+	}
+
+	for _, test := range tests {
+		if test.actual != test.expected {
+			t.Errorf("%s - expected %v, got %v", test.name, test.expected, test.actual)
+		}
+	}
+}
+
+func TestLoadConfigEnvReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "password"), []byte("filesecret\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write password file - %v", err)
+	}
+
+	configFilePath := writeTestConfigFile(t, dir, `
+irods_host: ${LIA_TEST_HOST}
+irods_admin_password_file: ${LIA_TEST_PASSWORD_DIR}/password
+naming_contexts:
+  - base_dn: "dc=other,dc=org"
+    irods_host: "other.example.org"
+    irods_zone: "other"
+    irods_admin_password: ${LIA_TEST_PASSWORD}
+`)
+
+	restoreEnv := setTestEnv(t, map[string]string{
+		"LIA_TEST_HOST":         "data.example.org",
+		"LIA_TEST_PASSWORD":     "envsecret",
+		"LIA_TEST_PASSWORD_DIR": dir,
+		// env and flags are taken literally
+		"LDAP_IRODS_AUTH_IRODS_ZONE": "${LIA_TEST_ZONE}",
+	})
+	defer restoreEnv()
+
+	config, err := LoadConfig(configFilePath, ConfigFlags{
+		"ldap_base_dn": "dc=${org}",
+	})
+	if err != nil {
+		t.Fatalf("failed to load config - %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		actual   interface{}
+		expected interface{}
+	}{
+		{"YAML reference", config.IRODSHost, "data.example.org"},
+		{"YAML file path reference", config.IRODSAdminPassword, "filesecret"},
+		{"YAML naming context reference", config.NamingContexts[0].IRODSAdminPassword, "envsecret"},
+		{"env literal", config.IRODSZone, "${LIA_TEST_ZONE}"},
+		{"flag literal", config.LDAPBaseDN, "dc=${org}"},
+	}
+
+	for _, test := range tests {
+		if test.actual != test.expected {
+			t.Errorf("%s - expected %v, got %v", test.name, test.expected, test.actual)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		yamlText string
+		flags    ConfigFlags
+	}{
+		{"unknown key", "irods_hostname: data.example.org\n", ConfigFlags{}},
+		{"wrong type", "service_port: many\n", ConfigFlags{}},
+		{"unknown flag key", "", ConfigFlags{"irods_hostname": "data.example.org"}},
+		{"invalid flag value", "", ConfigFlags{"service_port": "many"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFilePath := writeTestConfigFile(t, dir, test.yamlText)
+			if _, err := LoadConfig(configFilePath, test.flags); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "missing.yaml"), ConfigFlags{}); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestAddConfigFlags(t *testing.T) {
+	flags := ConfigFlags{}
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	AddConfigFlags(flagSet, flags)
+	AddConfigFlagAlias(flagSet, flags, "port", "service_port", "Set service port")
+
+	err := flagSet.Parse([]string{"-irods_host", "data.example.org", "-port", "3000", "-auth_cache_sliding", "-ldap_bind_name_resolvers", "uid,upn"})
+	if err != nil {
+		t.Fatalf("failed to parse flags - %v", err)
+	}
+
+	config := NewDefaultConfig()
+	for key, value := range flags {
+		err := config.setField(key, value)
+		if err != nil {
+			t.Fatalf("failed to set %s - %v", key, err)
+		}
+	}
+
+	if config.IRODSHost != "data.example.org" || config.ServicePort != 3000 || !config.AuthCacheSliding {
+		t.Errorf("unexpected config from flags %+v", flags)
+	}
+
+	if len(config.LDAPBindNameResolvers) != 2 || config.LDAPBindNameResolvers[1] != "upn" {
+		t.Errorf("unexpected bind name resolvers %v", config.LDAPBindNameResolvers)
+	}
+
+	// values are validated when parsed
+	err = flagSet.Parse([]string{"-service_port", "many"})
+	if err == nil {
+		t.Error("expected an error for an invalid flag value")
+	}
+}
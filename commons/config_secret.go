@@ -0,0 +1,192 @@
+package commons
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RedactedValue replaces secrets in output
+	RedactedValue string = "******"
+)
+
+var (
+	// ${NAME} is replaced with the env var, $${NAME} is kept as ${NAME}
+	envReferenceRegexp = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// secretField is a config value holding a secret, that can be read from a file instead
+type secretField struct {
+	context  string // base DN of the naming context, empty for the top level
+	key      string
+	fileKey  string
+	value    *string
+	filePath *string
+}
+
+// getSecretFields returns secret fields of the config and its naming contexts
+func (config *Config) getSecretFields() []secretField {
+	fields := []secretField{
+		{"", "irods_admin_password", "irods_admin_password_file", &config.IRODSAdminPassword, &config.IRODSAdminPasswordFile},
+		{"", "tls_key", "tls_key_path", &config.TLSKey, &config.TLSKeyPath},
+	}
+
+	for i := range config.NamingContexts {
+		namingContext := &config.NamingContexts[i]
+		fields = append(fields, secretField{namingContext.BaseDN, "irods_admin_password", "irods_admin_password_file", &namingContext.IRODSAdminPassword, &namingContext.IRODSAdminPasswordFile})
+	}
+	return fields
+}
+
+// ResolveSecrets reads secrets given as files into their fields, file paths are kept.
+// It must be called once on a loaded config
+func (config *Config) ResolveSecrets() error {
+	for _, field := range config.getSecretFields() {
+		secret, err := readSecret(field.key, field.fileKey, *field.value, *field.filePath)
+		if err != nil {
+			if len(field.context) > 0 {
+				return fmt.Errorf("Naming context %q - %v", field.context, err)
+			}
+			return err
+		}
+		*field.value = secret
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config with secrets replaced, for output
+func (config *Config) Redacted() *Config {
+	redacted := *config
+
+	if config.NamingContexts != nil {
+		redacted.NamingContexts = make([]NamingContextConfig, len(config.NamingContexts))
+		copy(redacted.NamingContexts, config.NamingContexts)
+	}
+
+	secretKeys := map[string]bool{
+		// naming contexts may have secrets
+		"naming_contexts": true,
+	}
+	for _, field := range redacted.getSecretFields() {
+		*field.value = redactSecret(*field.value)
+		secretKeys[field.key] = true
+	}
+
+	if config.ConfigFlags != nil {
+		redacted.ConfigFlags = ConfigFlags{}
+		for key, value := range config.ConfigFlags {
+			if secretKeys[key] {
+				redacted.ConfigFlags[key] = redactSecret(value)
+			} else {
+				redacted.ConfigFlags[key] = value
+			}
+		}
+	}
+	return &redacted
+}
+
+// RedactString replaces secrets of the config appearing in the text
+func (config *Config) RedactString(text string) string {
+	for _, field := range config.getSecretFields() {
+		if len(*field.value) > 0 {
+			text = strings.ReplaceAll(text, *field.value, RedactedValue)
+		}
+	}
+	return text
+}
+
+// redactSecret replaces a secret that is given
+func redactSecret(secret string) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	return RedactedValue
+}
+
+// readSecret returns the secret, read from the file if the path is given
+func readSecret(key string, fileKey string, value string, filePath string) (string, error) {
+	if len(filePath) == 0 {
+		return value, nil
+	}
+
+	if len(value) > 0 {
+		return "", fmt.Errorf("%s and %s must not be given together", key, fileKey)
+	}
+
+	secretBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s %s - %v", fileKey, filePath, err)
+	}
+
+	// files usually end with a newline
+	return strings.TrimRight(string(secretBytes), "\r\n"), nil
+}
+
+// interpolateEnv replaces ${ENV} references in string values of the struct read from YAML, except fields not set by flags
+func interpolateEnv(value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.String:
+		interpolated, err := expandEnvReferences(value.String())
+		if err != nil {
+			return err
+		}
+		value.SetString(interpolated)
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			err := interpolateEnv(value.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+
+		for _, key := range value.MapKeys() {
+			interpolated, err := expandEnvReferences(value.MapIndex(key).String())
+			if err != nil {
+				return err
+			}
+			value.SetMapIndex(key, reflect.ValueOf(interpolated).Convert(value.Type().Elem()))
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).Tag.Get("flag") == "-" {
+				continue
+			}
+
+			err := interpolateEnv(value.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnvReferences replaces ${ENV} references in the text, env vars referenced must be set
+func expandEnvReferences(text string) (string, error) {
+	var err error
+	expanded := envReferenceRegexp.ReplaceAllStringFunc(text, func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			// escaped
+			return reference[1:]
+		}
+
+		name := envReferenceRegexp.FindStringSubmatch(reference)[1]
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("env var %s referenced in config is not set", name)
+			}
+			return reference
+		}
+		return envValue
+	})
+	return expanded, err
+}
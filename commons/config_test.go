@@ -0,0 +1,74 @@
+package commons
+
+import (
+	"testing"
+)
+
+func TestConfigRequiresRestart(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(config *Config)
+		expected bool
+	}{
+		{"unchanged", func(config *Config) {}, false},
+		{"grace period", func(config *Config) { config.AuthGracePeriod = 600 }, false},
+		{"rate limit", func(config *Config) { config.RateLimitIPRate = 1 }, false},
+		{"lockout", func(config *Config) { config.AuthLockoutThresholdDN = 1 }, false},
+		{"email negative cache", func(config *Config) { config.LDAPUsernameEmailNegativeCacheTimeout = 5 }, false},
+		{"naming context attributes", func(config *Config) {
+			config.NamingContexts[0].LDAPAttributes = map[string]string{"mail": "{uid}@other.org"}
+		}, false},
+		{"service port", func(config *Config) { config.ServicePort = 10389 }, true},
+		{"naming context host", func(config *Config) { config.NamingContexts[0].IRODSHost = "moved.other.org" }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newValidTestConfig()
+			config.NamingContexts = []NamingContextConfig{
+				{BaseDN: "dc=other,dc=org", IRODSHost: "data.other.org", IRODSZone: "other"},
+			}
+
+			newConfig := *config
+			newConfig.NamingContexts = append([]NamingContextConfig{}, config.NamingContexts...)
+			test.modify(&newConfig)
+
+			if actual := config.RequiresRestart(&newConfig); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestConfigMergeReloadable(t *testing.T) {
+	config := newValidTestConfig()
+	config.NamingContexts = []NamingContextConfig{
+		{BaseDN: "dc=other,dc=org", IRODSHost: "data.other.org", IRODSZone: "other"},
+	}
+
+	newConfig := newValidTestConfig()
+	newConfig.ServicePort = 10389
+	newConfig.AuthGracePeriod = 600
+	newConfig.RateLimitDNBurst = 1
+	newConfig.NamingContexts = []NamingContextConfig{
+		{BaseDN: "dc=other,dc=org", IRODSHost: "moved.other.org", IRODSZone: "other", IRODSUserGroup: "staff"},
+	}
+
+	merged := config.MergeReloadable(newConfig)
+	if merged.AuthGracePeriod != 600 || merged.RateLimitDNBurst != 1 || merged.NamingContexts[0].IRODSUserGroup != "staff" {
+		t.Errorf("reloadable settings must be taken from the new config, got %+v", merged)
+	}
+
+	if merged.ServicePort != config.ServicePort || merged.NamingContexts[0].IRODSHost != "data.other.org" {
+		t.Errorf("settings requiring a restart must be kept, got %+v", merged)
+	}
+
+	// until restarted, every reload warns about the same change
+	if !merged.RequiresRestart(newConfig) {
+		t.Error("merged config must still require a restart for the new config")
+	}
+
+	if config.AuthGracePeriod == 600 || config.NamingContexts[0].IRODSUserGroup == "staff" {
+		t.Error("config must not be changed by merging")
+	}
+}
@@ -0,0 +1,537 @@
+package commons
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	hostnameRegexp      = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*\.?$`)
+	attributeNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+	templateRegexp      = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// ConfigError reports all problems found in a config
+type ConfigError struct {
+	Problems []string
+}
+
+// Error returns error message
+func (err *ConfigError) Error() string {
+	if len(err.Problems) == 1 {
+		return err.Problems[0]
+	}
+	return fmt.Sprintf("%d problems found - %s", len(err.Problems), strings.Join(err.Problems, "; "))
+}
+
+// configProblems collects problems found in validation
+type configProblems []string
+
+func (problems *configProblems) add(format string, args ...interface{}) {
+	*problems = append(*problems, fmt.Sprintf(format, args...))
+}
+
+// Validate validates configuration, returns ConfigError reporting all problems found.
+// Files and directories given are checked to be accessible
+func (config *Config) Validate() error {
+	problems := configProblems{}
+
+	config.validateService(&problems)
+
+	baseDNs := map[string]bool{}
+	for _, contextConfig := range config.GetNamingContexts() {
+		contextProblems := configProblems{}
+		contextConfig.validateNamingContext(&contextProblems)
+		for _, problem := range contextProblems {
+			if len(config.NamingContexts) > 0 {
+				problems.add("Naming context %q - %s", contextConfig.LDAPBaseDN, problem)
+			} else {
+				problems.add("%s", problem)
+			}
+		}
+
+		if baseDNs[contextConfig.LDAPBaseDN] {
+			problems.add("Naming context %q is given more than once", contextConfig.LDAPBaseDN)
+		}
+		baseDNs[contextConfig.LDAPBaseDN] = true
+	}
+
+	config.validateBindNames(&problems)
+	config.validateIRODS(&problems)
+	config.validateAuthCache(&problems)
+	config.validateAuthPolicy(&problems)
+	config.validateLimits(&problems)
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// validateService validates listeners, TLS, logging and the admin API
+func (config *Config) validateService(problems *configProblems) {
+	if len(config.ServiceHost) > 0 && !isValidHost(config.ServiceHost) {
+		problems.add("Service host %q is not a valid IP address or hostname", config.ServiceHost)
+	}
+
+	if config.ServicePort <= 0 {
+		problems.add("Service port must be given")
+	} else if !isValidPort(config.ServicePort) {
+		problems.add("Service port %d is out of range", config.ServicePort)
+	}
+
+	if config.ServiceTLSPort < 0 {
+		problems.add("Service TLS port must not be negative")
+	} else if config.ServiceTLSPort > 0 && !isValidPort(config.ServiceTLSPort) {
+		problems.add("Service TLS port %d is out of range", config.ServiceTLSPort)
+	}
+
+	if config.ServiceTLSPort > 0 && config.ServiceTLSPort == config.ServicePort {
+		problems.add("Service TLS port must differ from the service port")
+	}
+
+	hasTLSKey := len(config.TLSKeyPath) > 0 || len(config.TLSKey) > 0
+	if (len(config.TLSCertPath) == 0) != !hasTLSKey {
+		problems.add("TLS certificate and key must be given together")
+	} else if len(config.TLSCertPath) > 0 {
+		_, err := config.LoadTLSCertificate()
+		if err != nil {
+			problems.add("TLS certificate %s cannot be loaded - %v", config.TLSCertPath, err)
+		}
+	}
+
+	if config.ServiceTLSPort > 0 && len(config.TLSCertPath) == 0 {
+		problems.add("TLS certificate and key must be given to serve LDAPS")
+	}
+
+	_, err := log.ParseLevel(config.LogLevel)
+	if err != nil {
+		problems.add("Log level is invalid - %v", err)
+	}
+
+	if len(config.LogPath) > 0 && config.LogPath != "-" {
+		err := checkFileWritable(config.LogPath)
+		if err != nil {
+			problems.add("Log path %s is not writable - %v", config.LogPath, err)
+		}
+	}
+
+	if len(config.AdminSocketPath) > 0 {
+		err := checkDirExists(filepath.Dir(config.AdminSocketPath))
+		if err != nil {
+			problems.add("Admin socket path %s cannot be created - %v", config.AdminSocketPath, err)
+		}
+	}
+
+	if len(config.PIDFilePath) > 0 {
+		err := checkDirExists(filepath.Dir(config.PIDFilePath))
+		if err != nil {
+			problems.add("PID file path %s cannot be created - %v", config.PIDFilePath, err)
+		}
+	}
+}
+
+// validateNamingContext validates the base DN, iRODS backend and attributes
+func (config *Config) validateNamingContext(problems *configProblems) {
+	if len(config.LDAPBaseDN) == 0 {
+		problems.add("LDAP base DN must be given")
+	} else if err := validateDN(config.LDAPBaseDN); err != nil {
+		problems.add("LDAP base DN %q is malformed - %v", config.LDAPBaseDN, err)
+	}
+
+	if len(config.IRODSHost) == 0 {
+		problems.add("IRODS hostname must be given")
+	} else if !isValidHost(config.IRODSHost) {
+		problems.add("IRODS hostname %q is not a valid IP address or hostname", config.IRODSHost)
+	}
+
+	if config.IRODSPort <= 0 {
+		problems.add("IRODS port must be given")
+	} else if !isValidPort(config.IRODSPort) {
+		problems.add("IRODS port %d is out of range", config.IRODSPort)
+	}
+
+	if len(config.IRODSZone) == 0 {
+		problems.add("IRODS zone must be given")
+	} else if !isValidIRODSZone(config.IRODSZone) {
+		problems.add("IRODS zone %q must not contain '#', '/' or spaces", config.IRODSZone)
+	}
+
+	zoneNames := map[string]bool{
+		config.IRODSZone: true,
+	}
+	for _, zone := range config.IRODSZones {
+		if len(zone.Zone) == 0 || len(zone.Host) == 0 {
+			problems.add("IRODS zone table entries must have zone and host")
+			continue
+		}
+
+		if !isValidIRODSZone(zone.Zone) {
+			problems.add("IRODS zone %q must not contain '#', '/' or spaces", zone.Zone)
+		}
+
+		if !isValidHost(zone.Host) {
+			problems.add("IRODS zone %q host %q is not a valid IP address or hostname", zone.Zone, zone.Host)
+		}
+
+		if zone.Port < 0 || (zone.Port > 0 && !isValidPort(zone.Port)) {
+			problems.add("IRODS zone %q port %d is out of range", zone.Zone, zone.Port)
+		}
+
+		if zoneNames[zone.Zone] {
+			problems.add("IRODS zone %q is given more than once", zone.Zone)
+		}
+		zoneNames[zone.Zone] = true
+	}
+
+	if config.LDAPUsernameCaseFold {
+		// zones in bind names are case folded with usernames
+		foldedZoneNames := map[string]string{}
+		for zoneName := range zoneNames {
+			folded := strings.ToLower(zoneName)
+			if other, ok := foldedZoneNames[folded]; ok {
+				problems.add("IRODS zones %q and %q differ only in case, which cannot be told apart with LDAP username case folding", other, zoneName)
+			}
+			foldedZoneNames[folded] = zoneName
+		}
+	}
+
+	if (len(config.IRODSAdminUsername) == 0) != (len(config.IRODSAdminPassword) == 0) {
+		problems.add("IRODS admin username and password must be given together")
+	}
+
+	if len(config.LDAPUsernameEmailAVU) > 0 && !config.HasIRODSServiceAccount() {
+		problems.add("IRODS admin username and password must be given to look up usernames by email")
+	}
+
+	if len(config.LDAPUsernameEmailAVU) > 0 {
+		if config.LDAPUsernameEmailCacheTimeout <= 0 {
+			problems.add("LDAP username email cache timeout must be a positive number of seconds")
+		}
+
+		if config.LDAPUsernameEmailNegativeCacheTimeout < 0 {
+			problems.add("LDAP username email negative cache timeout must not be negative")
+		}
+	}
+
+	for name, template := range config.LDAPAttributes {
+		if !attributeNameRegexp.MatchString(name) {
+			problems.add("LDAP attribute name %q is invalid", name)
+		}
+
+		for _, placeholder := range templateRegexp.FindAllString(template, -1) {
+			switch placeholder {
+			case "{uid}", "{username}", "{zone}":
+			default:
+				problems.add("LDAP attribute %q has an unknown placeholder %s", name, placeholder)
+			}
+		}
+	}
+}
+
+// validateBindNames validates bind name resolution and username normalization
+func (config *Config) validateBindNames(problems *configProblems) {
+	if len(config.LDAPBindNameResolvers) == 0 {
+		problems.add("LDAP bind name resolvers must be given")
+	}
+
+	for _, resolver := range config.LDAPBindNameResolvers {
+		switch resolver {
+		case "uid", "cn", "upn", "bare":
+		case "regex":
+			if len(config.LDAPBindNameRegex) == 0 {
+				problems.add("LDAP bind name regex must be given to use the regex resolver")
+				continue
+			}
+
+			expression, err := regexp.Compile(config.LDAPBindNameRegex)
+			if err != nil {
+				problems.add("LDAP bind name regex is invalid - %v", err)
+				continue
+			}
+
+			hasUserGroup := false
+			for _, groupName := range expression.SubexpNames() {
+				if groupName == "user" {
+					hasUserGroup = true
+				}
+			}
+
+			if !hasUserGroup {
+				problems.add("LDAP bind name regex must have a \"user\" group")
+			}
+		default:
+			problems.add("Unknown LDAP bind name resolver %q", resolver)
+		}
+	}
+
+	for alias, username := range config.LDAPUsernameAliases {
+		if len(alias) == 0 || len(username) == 0 {
+			problems.add("LDAP username aliases must have alias and username")
+		} else if strings.Count(username, "#") > 1 {
+			problems.add("LDAP username alias %q must map to \"user\" or \"user#zone\"", alias)
+		}
+	}
+
+	if !isValidQueryValue(config.LDAPUsernameEmailAVU) {
+		problems.add("LDAP username email AVU %q must not contain quotes or backslashes", config.LDAPUsernameEmailAVU)
+	}
+}
+
+// validateIRODS validates iRODS connections
+func (config *Config) validateIRODS(problems *configProblems) {
+	if config.IRODSConnectTimeout <= 0 {
+		problems.add("IRODS connect timeout must be a positive number of seconds")
+	}
+
+	if config.IRODSOperationTimeout <= 0 {
+		problems.add("IRODS operation timeout must be a positive number of seconds")
+	}
+
+	if len(config.IRODSApplicationName) == 0 {
+		problems.add("IRODS application name must be given")
+	}
+
+	if config.IRODSTCPKeepAlive && config.IRODSTCPKeepAlivePeriod <= 0 {
+		problems.add("IRODS TCP keepalive period must be a positive number of seconds")
+	}
+
+	if config.IRODSPoolMaxConnections <= 0 {
+		problems.add("IRODS pool max connections must be a positive number")
+	}
+
+	if config.IRODSPoolIdleTimeout <= 0 {
+		problems.add("IRODS pool idle timeout must be a positive number of seconds")
+	}
+
+	if config.IRODSPoolHealthCheckInterval < 0 {
+		problems.add("IRODS pool health check interval must not be negative")
+	}
+}
+
+// validateAuthCache validates the auth cache and its persistence
+func (config *Config) validateAuthCache(problems *configProblems) {
+	if config.AuthCacheTimeout < 0 {
+		problems.add("Auth cache timeout must not be negative")
+	}
+
+	if config.AuthCacheMaxLifetime < 0 {
+		problems.add("Auth cache max lifetime must not be negative")
+	}
+
+	if config.AuthCacheMaxLifetime > 0 && config.AuthCacheMaxLifetime < config.AuthCacheTimeout {
+		problems.add("Auth cache max lifetime must not be smaller than auth cache timeout")
+	}
+
+	if config.AuthCacheMaxEntries < 0 {
+		problems.add("Auth cache max entries must not be negative")
+	}
+
+	if config.AuthCacheCleanupInterval <= 0 {
+		problems.add("Auth cache cleanup interval must be a positive number of seconds")
+	}
+
+	if config.AuthRevalidationInterval < 0 {
+		problems.add("Auth revalidation interval must not be negative")
+	}
+
+	if config.AuthGracePeriod < 0 {
+		problems.add("Auth grace period must not be negative")
+	}
+
+	if len(config.AuthCachePersistPath) > 0 {
+		if len(config.AuthCacheKeyFilePath) == 0 {
+			problems.add("Auth cache key file must be given to persist auth cache")
+		} else if _, err := os.Stat(config.AuthCacheKeyFilePath); err != nil {
+			problems.add("Auth cache key file %s is not accessible - %v", config.AuthCacheKeyFilePath, err)
+		}
+
+		err := checkDirExists(filepath.Dir(config.AuthCachePersistPath))
+		if err != nil {
+			problems.add("Auth cache persist path %s cannot be created - %v", config.AuthCachePersistPath, err)
+		}
+	}
+
+	if config.AuthCacheKDFTime <= 0 {
+		problems.add("Auth cache KDF time must be a positive number")
+	}
+
+	if config.AuthCacheKDFThreads <= 0 || config.AuthCacheKDFThreads > 255 {
+		problems.add("Auth cache KDF threads must be between 1 and 255")
+	}
+
+	if config.AuthCacheKDFMemory < AuthCacheKDFMemoryMin {
+		problems.add("Auth cache KDF memory must be at least %dKiB", AuthCacheKDFMemoryMin)
+	}
+
+	if config.AuthCacheKDFConcurrency <= 0 {
+		problems.add("Auth cache KDF concurrency must be a positive number")
+	}
+}
+
+// validateAuthPolicy validates the user policy
+func (config *Config) validateAuthPolicy(problems *configProblems) {
+	for _, userType := range append(append([]string{}, config.AuthAllowedUserTypes...), config.AuthDeniedUserTypes...) {
+		if !isValidIRODSUserType(userType) {
+			problems.add("Unknown IRODS user type %q", userType)
+		}
+	}
+
+	for _, zone := range append(append([]string{}, config.AuthAllowedZones...), config.AuthDeniedZones...) {
+		if !isValidIRODSZone(zone) {
+			problems.add("IRODS zone %q must not contain '#', '/' or spaces", zone)
+		}
+	}
+
+	if len(config.AuthDisabledAVUName) > 0 && len(config.AuthDisabledAVUValue) == 0 {
+		problems.add("Auth disabled AVU value must be given with auth disabled AVU name")
+	}
+
+	if !isValidQueryValue(config.AuthDisabledAVUName) {
+		problems.add("Auth disabled AVU name %q must not contain quotes or backslashes", config.AuthDisabledAVUName)
+	}
+}
+
+// validateLimits validates lockouts, rate limits and connection limits
+func (config *Config) validateLimits(problems *configProblems) {
+	if config.AuthNegativeCacheTimeout < 0 {
+		problems.add("Auth negative cache timeout must not be negative")
+	}
+
+	if config.AuthLockoutThresholdDN < 0 || config.AuthLockoutThresholdIP < 0 {
+		problems.add("Auth lockout thresholds must not be negative")
+	}
+
+	if config.AuthLockoutThresholdDN > 0 || config.AuthLockoutThresholdIP > 0 {
+		if config.AuthFailureWindow <= 0 {
+			problems.add("Auth failure window must be a positive number of seconds")
+		}
+
+		if config.AuthLockoutBase <= 0 {
+			problems.add("Auth lockout base must be a positive number of seconds")
+		}
+
+		if config.AuthLockoutMax < config.AuthLockoutBase {
+			problems.add("Auth lockout max must not be smaller than auth lockout base")
+		}
+	}
+
+	if config.RateLimitIPRate < 0 || config.RateLimitDNRate < 0 {
+		problems.add("Rate limits must not be negative")
+	}
+
+	if (config.RateLimitIPRate > 0 && config.RateLimitIPBurst <= 0) || (config.RateLimitDNRate > 0 && config.RateLimitDNBurst <= 0) {
+		problems.add("Rate limit bursts must be positive when rate limits are set")
+	}
+
+	if config.MaxConnections < 0 || config.MaxConnectionsPerIP < 0 {
+		problems.add("Connection limits must not be negative")
+	}
+
+	if config.ShutdownDrainTimeout < 0 {
+		problems.add("Shutdown drain timeout must not be negative")
+	}
+}
+
+// isValidIRODSUserType checks if the user type is known to iRODS
+func isValidIRODSUserType(userType string) bool {
+	switch userType {
+	case "rodsuser", "rodsadmin", "groupadmin", "rodsgroup":
+		return true
+	default:
+		return false
+	}
+}
+
+// isValidIRODSZone checks if the zone name can be used in usernames and DNs
+func isValidIRODSZone(zone string) bool {
+	return !strings.ContainsAny(zone, "#/ \t")
+}
+
+// isValidQueryValue checks if the value can be placed in an iRODS general query
+func isValidQueryValue(value string) bool {
+	return !strings.ContainsAny(value, "'\\")
+}
+
+// isValidHost checks if the host is an IP address or a hostname
+func isValidHost(host string) bool {
+	return net.ParseIP(host) != nil || hostnameRegexp.MatchString(host)
+}
+
+// isValidPort checks if the port is in the TCP port range
+func isValidPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// validateDN checks if the DN is a sequence of attribute=value RDNs
+func validateDN(dn string) error {
+	for _, rdn := range strings.Split(dn, ",") {
+		kv := strings.SplitN(rdn, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("RDN %q is not attribute=value", strings.TrimSpace(rdn))
+		}
+
+		if !attributeNameRegexp.MatchString(strings.TrimSpace(kv[0])) {
+			return fmt.Errorf("RDN %q has an invalid attribute", strings.TrimSpace(rdn))
+		}
+
+		if len(strings.TrimSpace(kv[1])) == 0 {
+			return fmt.Errorf("RDN %q has an empty value", strings.TrimSpace(rdn))
+		}
+	}
+	return nil
+}
+
+// checkDirExists checks if the path is a directory
+func checkDirExists(dirPath string) error {
+	fileinfo, err := os.Stat(dirPath)
+	if err != nil {
+		return err
+	}
+
+	if !fileinfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", dirPath)
+	}
+	return nil
+}
+
+// checkFileWritable checks if the file can be written, or created in its directory if it does not exist
+func checkFileWritable(filePath string) error {
+	fileinfo, err := os.Stat(filePath)
+	if err == nil {
+		if fileinfo.IsDir() {
+			return fmt.Errorf("%s is a directory", filePath)
+		}
+
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	dirPath := filepath.Dir(filePath)
+	err = checkDirExists(dirPath)
+	if err != nil {
+		return err
+	}
+
+	// without leaving a file behind
+	file, err := ioutil.TempFile(dirPath, ".ldap-irods-auth-check-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,193 @@
+package commons
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newValidTestConfig returns a valid config that does not touch files
+func newValidTestConfig() *Config {
+	config := NewDefaultConfig()
+	config.IRODSHost = "data.example.org"
+	config.IRODSZone = "iplant"
+	config.LogPath = "-"
+	config.PIDFilePath = ""
+	return config
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := newValidTestConfig().Validate(); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(config *Config)
+		problem string // empty if valid
+	}{
+		{"no iRODS host", func(config *Config) { config.IRODSHost = "" }, "IRODS hostname must be given"},
+		{"invalid iRODS host", func(config *Config) { config.IRODSHost = "data example" }, "is not a valid IP address or hostname"},
+		{"iRODS port out of range", func(config *Config) { config.IRODSPort = 70000 }, "IRODS port 70000 is out of range"},
+		{"malformed base DN", func(config *Config) { config.LDAPBaseDN = "dc=example,org" }, "is malformed"},
+		{"TLS port without certificate", func(config *Config) { config.ServiceTLSPort = 1636 }, "TLS certificate and key must be given to serve LDAPS"},
+		{"same TLS port", func(config *Config) { config.ServiceTLSPort = config.ServicePort }, "must differ from the service port"},
+		{"invalid log level", func(config *Config) { config.LogLevel = "loud" }, "Log level is invalid"},
+		{"zone given twice", func(config *Config) {
+			config.IRODSZones = IRODSZoneTable{{Zone: "iplant", Host: "fed.example.org"}}
+		}, "is given more than once"},
+		{"zones differing in case", func(config *Config) {
+			config.LDAPUsernameCaseFold = true
+			config.IRODSZones = IRODSZoneTable{{Zone: "IPlant", Host: "fed.example.org"}}
+		}, "differ only in case"},
+		{"zones differing in case without case folding", func(config *Config) {
+			config.IRODSZones = IRODSZoneTable{{Zone: "IPlant", Host: "fed.example.org"}}
+		}, ""},
+		{"admin username without password", func(config *Config) { config.IRODSAdminUsername = "rods" }, "must be given together"},
+		{"email lookup without service account", func(config *Config) { config.LDAPUsernameEmailAVU = "email" }, "to look up usernames by email"},
+		{"email cache without timeout", func(config *Config) {
+			config.IRODSAdminUsername = "rods"
+			config.IRODSAdminPassword = "secret"
+			config.LDAPUsernameEmailAVU = "email"
+			config.LDAPUsernameEmailCacheTimeout = 0
+		}, "email cache timeout must be a positive number"},
+		{"quoted email AVU", func(config *Config) {
+			config.IRODSAdminUsername = "rods"
+			config.IRODSAdminPassword = "secret"
+			config.LDAPUsernameEmailAVU = "email'"
+		}, "must not contain quotes"},
+		{"unknown placeholder", func(config *Config) { config.LDAPAttributes = map[string]string{"mail": "{user}@example.org"} }, "unknown placeholder {user}"},
+		{"invalid attribute name", func(config *Config) { config.LDAPAttributes = map[string]string{"mail address": "{uid}"} }, "LDAP attribute name \"mail address\" is invalid"},
+		{"no resolvers", func(config *Config) { config.LDAPBindNameResolvers = []string{} }, "resolvers must be given"},
+		{"unknown resolver", func(config *Config) { config.LDAPBindNameResolvers = []string{"mail"} }, "Unknown LDAP bind name resolver \"mail\""},
+		{"regex resolver without regex", func(config *Config) { config.LDAPBindNameResolvers = []string{"regex"} }, "regex must be given"},
+		{"regex without user group", func(config *Config) {
+			config.LDAPBindNameResolvers = []string{"regex"}
+			config.LDAPBindNameRegex = "^(.+)@example\\.org$"
+		}, "must have a \"user\" group"},
+		{"alias to two zones", func(config *Config) { config.LDAPUsernameAliases = map[string]string{"alice": "alice#a#b"} }, "must map to"},
+		{"zero connect timeout", func(config *Config) { config.IRODSConnectTimeout = 0 }, "IRODS connect timeout must be a positive number"},
+		{"max lifetime under TTL", func(config *Config) {
+			config.AuthCacheTimeout = 600
+			config.AuthCacheMaxLifetime = 300
+		}, "must not be smaller than auth cache timeout"},
+		{"persist without key file", func(config *Config) { config.AuthCachePersistPath = filepath.Join(os.TempDir(), "auth_cache.db") }, "key file must be given"},
+		{"KDF memory under floor", func(config *Config) { config.AuthCacheKDFMemory = AuthCacheKDFMemoryMin - 1 }, "KDF memory must be at least"},
+		{"KDF memory at floor", func(config *Config) { config.AuthCacheKDFMemory = AuthCacheKDFMemoryMin }, ""},
+		{"KDF threads out of range", func(config *Config) { config.AuthCacheKDFThreads = 256 }, "KDF threads must be between 1 and 255"},
+		{"unknown user type", func(config *Config) { config.AuthAllowedUserTypes = []string{"rodsguest"} }, "Unknown IRODS user type \"rodsguest\""},
+		{"disabled AVU without value", func(config *Config) {
+			config.AuthDisabledAVUName = "disabled"
+			config.AuthDisabledAVUValue = ""
+		}, "value must be given"},
+		{"lockout max under base", func(config *Config) {
+			config.AuthLockoutBase = 60
+			config.AuthLockoutMax = 30
+		}, "must not be smaller than auth lockout base"},
+		{"lockouts disabled", func(config *Config) {
+			config.AuthLockoutThresholdDN = 0
+			config.AuthLockoutThresholdIP = 0
+			config.AuthLockoutBase = 0
+		}, ""},
+		{"rate without burst", func(config *Config) { config.RateLimitIPBurst = 0 }, "bursts must be positive"},
+		{"negative connection limit", func(config *Config) { config.MaxConnections = -1 }, "Connection limits must not be negative"},
+		{"admin socket in missing dir", func(config *Config) { config.AdminSocketPath = "/nonexistent/ldap-irods-auth/admin.sock" }, "Admin socket path"},
+		{"naming context", func(config *Config) {
+			config.NamingContexts = []NamingContextConfig{
+				{BaseDN: "dc=other,dc=org", IRODSZone: "other"},
+			}
+		}, "Naming context \"dc=other,dc=org\" - IRODS hostname must be given"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newValidTestConfig()
+			test.modify(config)
+
+			err := config.Validate()
+			if len(test.problem) == 0 {
+				if err != nil {
+					t.Errorf("expected a valid config, got %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected a problem %q", test.problem)
+			}
+
+			if !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("expected a problem %q, got %v", test.problem, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidateReportsAllProblems(t *testing.T) {
+	config := newValidTestConfig()
+	config.IRODSHost = ""
+	config.ServicePort = 0
+	config.AuthCacheKDFThreads = 0
+
+	err := config.Validate()
+	configErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("expected a ConfigError, got %v", err)
+	}
+
+	if len(configErr.Problems) != 3 {
+		t.Errorf("expected 3 problems, got %v", configErr.Problems)
+	}
+}
+
+func TestNewConfigFromYAMLStrict(t *testing.T) {
+	_, err := NewConfigFromYAML([]byte("irods_host: data.example.org\nirods_hostname: data.example.org\n"))
+	if err == nil {
+		t.Error("unknown keys must be errors")
+	}
+
+	config, err := NewConfigFromYAML([]byte("irods_host: data.example.org\nirods_zone: iplant\nlog_path: \"-\"\npid_file_path: \"\"\n"))
+	if err != nil {
+		t.Fatalf("failed to read config - %v", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+}
+
+func TestConfigValidateFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFilePath := filepath.Join(dir, "auth_cache.key")
+	err = ioutil.WriteFile(keyFilePath, []byte("0123456789abcdef0123456789abcdef"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write key file - %v", err)
+	}
+
+	config := newValidTestConfig()
+	config.AuthCachePersistPath = filepath.Join(dir, "auth_cache.db")
+	config.AuthCacheKeyFilePath = keyFilePath
+	config.LogPath = filepath.Join(dir, "ldap-irods-auth.log")
+	config.AdminSocketPath = filepath.Join(dir, "admin.sock")
+	config.PIDFilePath = filepath.Join(dir, "ldap-irods-auth.pid")
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+
+	config.AuthCacheKeyFilePath = filepath.Join(dir, "missing.key")
+	config.TLSCertPath = filepath.Join(dir, "missing.crt")
+	config.TLSKeyPath = filepath.Join(dir, "missing.key")
+
+	err = config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "is not accessible") || !strings.Contains(err.Error(), "cannot be loaded") {
+		t.Errorf("expected problems of missing files, got %v", err)
+	}
+}
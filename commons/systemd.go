@@ -0,0 +1,154 @@
+package commons
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// SystemdNotifyReady reports the service is ready to serve clients
+	SystemdNotifyReady string = "READY=1"
+	// SystemdNotifyReloading reports the service is reloading the config
+	SystemdNotifyReloading string = "RELOADING=1"
+	// SystemdNotifyStopping reports the service is shutting down
+	SystemdNotifyStopping string = "STOPPING=1"
+	// SystemdNotifyWatchdog keeps the watchdog from restarting the service
+	SystemdNotifyWatchdog string = "WATCHDOG=1"
+
+	// SystemdSocketNameLDAPS is FileDescriptorName of the socket serving LDAPS, other sockets serve LDAP
+	SystemdSocketNameLDAPS string = "ldaps"
+
+	// the first file descriptor passed by socket activation
+	systemdListenFDsStart int = 3
+)
+
+// SystemdNotify sends the states to systemd over NOTIFY_SOCKET.
+// It returns false without an error if the service is not run by systemd with notify
+func SystemdNotify(states ...string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if len(socketPath) == 0 {
+		return false, nil
+	}
+
+	// abstract namespace
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socketPath,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to systemd notify socket - %v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	if err != nil {
+		return false, fmt.Errorf("failed to notify systemd - %v", err)
+	}
+	return true, nil
+}
+
+// SystemdStatus returns a state describing the service status
+func SystemdStatus(status string) string {
+	return fmt.Sprintf("STATUS=%s", status)
+}
+
+// GetSystemdWatchdogInterval returns the watchdog timeout systemd expects notifications within,
+// 0 if the watchdog is not enabled for this process
+func GetSystemdWatchdogInterval() (time.Duration, error) {
+	watchdogUsec := os.Getenv("WATCHDOG_USEC")
+	if len(watchdogUsec) == 0 {
+		return 0, nil
+	}
+
+	usec, err := strconv.ParseInt(watchdogUsec, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, fmt.Errorf("failed to parse WATCHDOG_USEC %q", watchdogUsec)
+	}
+
+	// the watchdog may be meant for another process
+	watchdogPID := os.Getenv("WATCHDOG_PID")
+	if len(watchdogPID) > 0 {
+		pid, err := strconv.Atoi(watchdogPID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse WATCHDOG_PID %q", watchdogPID)
+		}
+
+		if pid != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	return time.Duration(usec) * time.Microsecond, nil
+}
+
+// GetSystemdListeners returns listeners passed by systemd socket activation with their FileDescriptorNames,
+// empty if none is passed. The env vars are unset, so listeners are taken once and not passed to child processes
+func GetSystemdListeners() ([]net.Listener, []string, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	listenPID := os.Getenv("LISTEN_PID")
+	listenFDs := os.Getenv("LISTEN_FDS")
+	if len(listenPID) == 0 || len(listenFDs) == 0 {
+		return nil, nil, nil
+	}
+
+	pid, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse LISTEN_PID %q", listenPID)
+	}
+
+	if pid != os.Getpid() {
+		// passed to another process
+		return nil, nil, nil
+	}
+
+	fds, err := strconv.Atoi(listenFDs)
+	if err != nil || fds < 0 {
+		return nil, nil, fmt.Errorf("failed to parse LISTEN_FDS %q", listenFDs)
+	}
+
+	names := []string{}
+	if listenFDNames := os.Getenv("LISTEN_FDNAMES"); len(listenFDNames) > 0 {
+		names = strings.Split(listenFDNames, ":")
+	}
+
+	listeners := []net.Listener{}
+	listenerNames := []string{}
+	for i := 0; i < fds; i++ {
+		fd := systemdListenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		listener, err := net.FileListener(file)
+		// the listener has its own copy of the file descriptor
+		file.Close()
+		if err != nil {
+			for _, created := range listeners {
+				created.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to use socket %q passed by systemd - %v", name, err)
+		}
+
+		listeners = append(listeners, listener)
+		listenerNames = append(listenerNames, name)
+	}
+	return listeners, listenerNames, nil
+}
@@ -0,0 +1,482 @@
+package ldap
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/ldap-irods-auth/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+// AuthCacheEntry is an entry of AuthCache
+type AuthCacheEntry struct {
+	DN       string              `json:"dn"`
+	Username string              `json:"username"`
+	Zone     string              `json:"zone"`
+	UserType string              `json:"user_type"`
+	Groups   []string            `json:"groups"` // iRODS groups at the last verification
+	Verifier *CredentialVerifier `json:"-"`
+	Verified time.Time           `json:"verified"`  // last successful verification against iRODS
+	LastUsed time.Time           `json:"last_used"` // last cache hit
+	Expires  time.Time           `json:"expires"`
+}
+
+// AuthCache is a size-bounded LRU cache of credential verifiers per DN.
+// Entries expire after the positive TTL, which may be renewed on use (sliding),
+// but never outlive the max lifetime since the last verification against iRODS.
+// Expired entries are retained for the grace period since the last verification,
+// to be used only while iRODS is unavailable
+type AuthCache struct {
+	ttl         time.Duration
+	maxLifetime time.Duration
+	sliding     bool
+	maxEntries  int
+	grace       time.Duration
+
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+	store   *AuthCacheStore
+	mutex   sync.Mutex
+
+	stopChan chan bool
+	stopOnce sync.Once
+}
+
+// NewAuthCache creates a new AuthCache and starts its janitor.
+// If a persist path is configured, entries are loaded from and written to the store
+func NewAuthCache(config *commons.Config) (*AuthCache, error) {
+	cache := &AuthCache{
+		ttl:         time.Duration(config.AuthCacheTimeout) * time.Second,
+		maxLifetime: time.Duration(config.AuthCacheMaxLifetime) * time.Second,
+		sliding:     config.AuthCacheSliding,
+		maxEntries:  config.AuthCacheMaxEntries,
+		grace:       time.Duration(config.AuthGracePeriod) * time.Second,
+
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+
+		stopChan: make(chan bool),
+	}
+
+	if len(config.AuthCachePersistPath) > 0 {
+		err := cache.openStore(config.AuthCachePersistPath, config.AuthCacheKeyFilePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cleanupInterval := time.Duration(config.AuthCacheCleanupInterval) * time.Second
+	if cleanupInterval > 0 {
+		go cache.runJanitor(cleanupInterval)
+	}
+
+	return cache, nil
+}
+
+// openStore opens the store and loads unexpired entries from it
+func (cache *AuthCache) openStore(path string, keyFilePath string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "AuthCache",
+		"function": "openStore",
+	})
+
+	store, err := NewAuthCacheStore(path, keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := store.Load()
+	if err != nil {
+		store.Close()
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.store = store
+
+	now := time.Now()
+	loaded := 0
+	for _, entry := range entries {
+		// apply current TTLs
+		expires := cache.getExpiry(entry.Verified, entry.LastUsed)
+		if expires.Before(entry.Expires) {
+			entry.Expires = expires
+		}
+
+		if !now.Before(cache.getRetention(entry)) {
+			store.Delete(entry.DN)
+			continue
+		}
+
+		cache.putEntry(entry)
+		loaded++
+	}
+
+	logger.Infof("Loaded %d auth cache entries from %s", loaded, path)
+	return nil
+}
+
+// Reconfigure applies TTLs and the size limit of the config, entries are shortened to the new TTLs
+func (cache *AuthCache) Reconfigure(config *commons.Config) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.ttl = time.Duration(config.AuthCacheTimeout) * time.Second
+	cache.maxLifetime = time.Duration(config.AuthCacheMaxLifetime) * time.Second
+	cache.sliding = config.AuthCacheSliding
+	cache.maxEntries = config.AuthCacheMaxEntries
+	cache.grace = time.Duration(config.AuthGracePeriod) * time.Second
+
+	for _, elem := range cache.entries {
+		entry := elem.Value.(*AuthCacheEntry)
+		expires := cache.getExpiry(entry.Verified, entry.LastUsed)
+		if expires.Before(entry.Expires) {
+			entry.Expires = expires
+			if cache.store != nil {
+				cache.store.Save(entry)
+			}
+		}
+	}
+
+	// evict least recently used entries
+	for cache.maxEntries > 0 && cache.lru.Len() > cache.maxEntries {
+		cache.removeElement(cache.lru.Back())
+	}
+}
+
+// Release stops the janitor and closes the store
+func (cache *AuthCache) Release() {
+	cache.stopOnce.Do(func() {
+		close(cache.stopChan)
+
+		cache.mutex.Lock()
+		defer cache.mutex.Unlock()
+
+		if cache.store != nil {
+			cache.store.Close()
+			cache.store = nil
+		}
+	})
+}
+
+// Get returns an unexpired entry for the DN
+func (cache *AuthCache) Get(dn string) (*AuthCacheEntry, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	elem, ok := cache.entries[dn]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*AuthCacheEntry)
+	now := time.Now()
+	if !now.Before(entry.Expires) {
+		if !now.Before(cache.getRetention(entry)) {
+			cache.removeElement(elem)
+		}
+		return nil, false
+	}
+
+	entryCopy := *entry
+	return &entryCopy, true
+}
+
+// GetGrace returns an entry for the DN verified within the grace period, even if expired
+func (cache *AuthCache) GetGrace(dn string) (*AuthCacheEntry, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.grace <= 0 {
+		return nil, false
+	}
+
+	elem, ok := cache.entries[dn]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*AuthCacheEntry)
+	if !time.Now().Before(entry.Verified.Add(cache.grace)) {
+		return nil, false
+	}
+
+	entryCopy := *entry
+	return &entryCopy, true
+}
+
+// Use marks the entry as used after its verifier matched, renewing it when sliding expiry is on.
+// A verifier loaded from the store is replaced with the same verifier given with a MAC
+func (cache *AuthCache) Use(dn string, verifier *CredentialVerifier) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	elem, ok := cache.entries[dn]
+	if !ok {
+		return
+	}
+
+	entry := elem.Value.(*AuthCacheEntry)
+	if !entry.Verifier.IsSame(verifier) {
+		// replaced in the meantime
+		return
+	}
+
+	if len(entry.Verifier.MAC) == 0 && len(verifier.MAC) > 0 {
+		entry.Verifier = verifier
+	}
+
+	now := time.Now()
+	if !now.Before(entry.Expires) {
+		// expired entries are not renewed
+		return
+	}
+
+	entry.LastUsed = now
+	if cache.sliding {
+		entry.Expires = cache.getExpiry(entry.Verified, now)
+		if cache.store != nil {
+			cache.store.Save(entry)
+		}
+	}
+
+	cache.lru.MoveToFront(elem)
+}
+
+// Set adds or replaces the entry for the DN after a successful verification against iRODS
+func (cache *AuthCache) Set(dn string, user *irodsclient_types.IRODSUser, groups []string, verifier *CredentialVerifier) {
+	now := time.Now()
+	cache.Put(&AuthCacheEntry{
+		DN:       dn,
+		Username: user.Name,
+		Zone:     user.Zone,
+		UserType: string(user.Type),
+		Groups:   groups,
+		Verifier: verifier,
+		Verified: now,
+		LastUsed: now,
+		Expires:  cache.getExpiry(now, now),
+	})
+}
+
+// Put adds or replaces the entry as given
+func (cache *AuthCache) Put(entry *AuthCacheEntry) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.putEntry(entry)
+	if cache.store != nil {
+		cache.store.Save(entry)
+	}
+}
+
+// putEntry adds or replaces the entry, the caller must hold the mutex
+func (cache *AuthCache) putEntry(entry *AuthCacheEntry) {
+	if elem, ok := cache.entries[entry.DN]; ok {
+		elem.Value = entry
+		cache.lru.MoveToFront(elem)
+		return
+	}
+
+	cache.entries[entry.DN] = cache.lru.PushFront(entry)
+
+	// evict least recently used entries
+	for cache.maxEntries > 0 && cache.lru.Len() > cache.maxEntries {
+		cache.removeElement(cache.lru.Back())
+	}
+}
+
+// Delete removes the entry for the DN
+func (cache *AuthCache) Delete(dn string) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if elem, ok := cache.entries[dn]; ok {
+		cache.removeElement(elem)
+		return true
+	}
+	return false
+}
+
+// DeleteByGroup removes entries of users in the group, returns the number of entries removed
+func (cache *AuthCache) DeleteByGroup(group string) int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	deleted := 0
+	for _, elem := range cache.entries {
+		entry := elem.Value.(*AuthCacheEntry)
+		for _, entryGroup := range entry.Groups {
+			if entryGroup == group {
+				cache.removeElement(elem)
+				deleted++
+				break
+			}
+		}
+	}
+	return deleted
+}
+
+// DeleteUnderBaseDN removes entries of DNs under the base DN, returns the number of entries removed
+func (cache *AuthCache) DeleteUnderBaseDN(baseDN string) int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	deleted := 0
+	for dn, elem := range cache.entries {
+		if IsDNUnderBaseDN(baseDN, dn) {
+			cache.removeElement(elem)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Flush removes all entries, returns the number of entries removed
+func (cache *AuthCache) Flush() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	deleted := len(cache.entries)
+	cache.entries = map[string]*list.Element{}
+	cache.lru.Init()
+
+	if cache.store != nil {
+		cache.store.Clear()
+	}
+	return deleted
+}
+
+// List returns unexpired entries, most recently used first
+func (cache *AuthCache) List() []AuthCacheEntry {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now()
+	entries := []AuthCacheEntry{}
+	for elem := cache.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*AuthCacheEntry)
+		if now.Before(entry.Expires) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// ListAll returns all entries including expired entries retained for the grace period
+func (cache *AuthCache) ListAll() []AuthCacheEntry {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entries := []AuthCacheEntry{}
+	for elem := cache.lru.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, *elem.Value.(*AuthCacheEntry))
+	}
+	return entries
+}
+
+// Update updates iRODS user info and groups of the entry, if it has not been replaced
+func (cache *AuthCache) Update(dn string, verifier *CredentialVerifier, user *irodsclient_types.IRODSUser, groups []string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	elem, ok := cache.entries[dn]
+	if !ok {
+		return
+	}
+
+	entry := elem.Value.(*AuthCacheEntry)
+	if !entry.Verifier.IsSame(verifier) {
+		return
+	}
+
+	entry.Zone = user.Zone
+	entry.UserType = string(user.Type)
+	entry.Groups = groups
+	if cache.store != nil {
+		cache.store.Save(entry)
+	}
+}
+
+// DeleteEntry removes the entry for the DN, if it has not been replaced
+func (cache *AuthCache) DeleteEntry(dn string, verifier *CredentialVerifier) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	elem, ok := cache.entries[dn]
+	if !ok {
+		return false
+	}
+
+	entry := elem.Value.(*AuthCacheEntry)
+	if !entry.Verifier.IsSame(verifier) {
+		return false
+	}
+
+	cache.removeElement(elem)
+	return true
+}
+
+// getExpiry returns expiry of an entry verified and used at the given times
+func (cache *AuthCache) getExpiry(verified time.Time, used time.Time) time.Time {
+	expires := used.Add(cache.ttl)
+	if cache.maxLifetime > 0 {
+		hardLimit := verified.Add(cache.maxLifetime)
+		if hardLimit.Before(expires) {
+			return hardLimit
+		}
+	}
+	return expires
+}
+
+// getRetention returns the time until the entry is kept in the cache
+func (cache *AuthCache) getRetention(entry *AuthCacheEntry) time.Time {
+	graceLimit := entry.Verified.Add(cache.grace)
+	if graceLimit.After(entry.Expires) {
+		return graceLimit
+	}
+	return entry.Expires
+}
+
+// removeElement removes the element, the caller must hold the mutex
+func (cache *AuthCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*AuthCacheEntry)
+	delete(cache.entries, entry.DN)
+	cache.lru.Remove(elem)
+
+	if cache.store != nil {
+		cache.store.Delete(entry.DN)
+	}
+}
+
+func (cache *AuthCache) deleteExpired() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now()
+	for _, elem := range cache.entries {
+		entry := elem.Value.(*AuthCacheEntry)
+		if !now.Before(cache.getRetention(entry)) {
+			cache.removeElement(elem)
+		}
+	}
+}
+
+func (cache *AuthCache) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cache.deleteExpired()
+		case <-cache.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package ldap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	authCacheStoreBucket    string        = "auth_cache"
+	authCacheStoreQueueSize int           = 1024
+	authCacheStoreTimeout   time.Duration = 5 * time.Second
+
+	authCacheKeyMinLength int = 16
+
+	// labels to derive independent keys from the key file
+	authCacheEncryptionKeyLabel string = "ldap-irods-auth auth cache encryption"
+	authCacheCredentialKeyLabel string = "ldap-irods-auth credential"
+)
+
+// persistedAuthCacheEntry is a form of AuthCacheEntry stored on disk
+type persistedAuthCacheEntry struct {
+	Entry    *AuthCacheEntry     `json:"entry"`
+	Verifier *CredentialVerifier `json:"verifier"`
+}
+
+// AuthCacheStore persists AuthCache entries to a bbolt file, encrypted with AES-GCM.
+// Writes are queued and applied in background so they do not block binds.
+// Pending writes for a DN are coalesced to the latest, saves are dropped if the queue is full
+// but removals are never dropped, so removed credentials do not come back after a restart
+type AuthCacheStore struct {
+	db   *bolt.DB
+	aead cipher.AEAD
+
+	pending      map[string]*AuthCacheEntry // nil to delete
+	pendingClear bool
+	closed       bool
+	pendingMutex sync.Mutex
+	wakeChan     chan bool
+	doneChan     chan bool
+}
+
+// readAuthCacheKey reads the secret from the key file and derives a key for the purpose given
+func readAuthCacheKey(keyFilePath string, label string) ([]byte, error) {
+	keyBytes, err := ioutil.ReadFile(keyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth cache key file %s - %v", keyFilePath, err)
+	}
+
+	secret := strings.TrimSpace(string(keyBytes))
+	if len(secret) < authCacheKeyMinLength {
+		return nil, fmt.Errorf("auth cache key in %s is too short, at least %d characters are required", keyFilePath, authCacheKeyMinLength)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(label))
+	return mac.Sum(nil), nil
+}
+
+// NewAuthCacheStore opens or creates the store file
+func NewAuthCacheStore(path string, keyFilePath string) (*AuthCacheStore, error) {
+	key, err := readAuthCacheKey(keyFilePath, authCacheEncryptionKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: authCacheStoreTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open auth cache store %s - %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(authCacheStoreBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize auth cache store %s - %v", path, err)
+	}
+
+	store := &AuthCacheStore{
+		db:       db,
+		aead:     aead,
+		pending:  map[string]*AuthCacheEntry{},
+		wakeChan: make(chan bool, 1),
+		doneChan: make(chan bool),
+	}
+
+	go store.runWriter()
+
+	return store, nil
+}
+
+// Close flushes pending writes and closes the store
+func (store *AuthCacheStore) Close() {
+	store.pendingMutex.Lock()
+	store.closed = true
+	store.pendingMutex.Unlock()
+
+	store.wake()
+	<-store.doneChan
+	store.db.Close()
+}
+
+// Load returns all entries in the store, entries that cannot be decrypted are skipped
+func (store *AuthCacheStore) Load() ([]*AuthCacheEntry, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "AuthCacheStore",
+		"function": "Load",
+	})
+
+	entries := []*AuthCacheEntry{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(authCacheStoreBucket))
+		return bucket.ForEach(func(k []byte, v []byte) error {
+			entry, err := store.decrypt(k, v)
+			if err != nil {
+				logger.WithError(err).Warnf("failed to decrypt auth cache entry of %s, skipping", string(k))
+				return nil
+			}
+
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Save queues a write of the entry, it is dropped if the queue is full
+func (store *AuthCacheStore) Save(entry *AuthCacheEntry) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "AuthCacheStore",
+		"function": "Save",
+	})
+
+	entryCopy := *entry
+
+	store.pendingMutex.Lock()
+	_, replacing := store.pending[entry.DN]
+	if !replacing && len(store.pending) >= authCacheStoreQueueSize {
+		store.pendingMutex.Unlock()
+		logger.Warnf("auth cache store queue is full, dropping a write for %s", entry.DN)
+		return
+	}
+
+	store.pending[entry.DN] = &entryCopy
+	store.pendingMutex.Unlock()
+
+	store.wake()
+}
+
+// Delete queues a removal of the entry for the DN
+func (store *AuthCacheStore) Delete(dn string) {
+	store.pendingMutex.Lock()
+	store.pending[dn] = nil
+	store.pendingMutex.Unlock()
+
+	store.wake()
+}
+
+// Clear queues a removal of all entries, writes queued before are discarded
+func (store *AuthCacheStore) Clear() {
+	store.pendingMutex.Lock()
+	store.pending = map[string]*AuthCacheEntry{}
+	store.pendingClear = true
+	store.pendingMutex.Unlock()
+
+	store.wake()
+}
+
+// wake notifies the writer of pending writes
+func (store *AuthCacheStore) wake() {
+	select {
+	case store.wakeChan <- true:
+	default:
+		// already notified
+	}
+}
+
+// takePending returns pending writes and resets them
+func (store *AuthCacheStore) takePending() (map[string]*AuthCacheEntry, bool, bool) {
+	store.pendingMutex.Lock()
+	defer store.pendingMutex.Unlock()
+
+	pending := store.pending
+	clear := store.pendingClear
+	store.pending = map[string]*AuthCacheEntry{}
+	store.pendingClear = false
+	return pending, clear, store.closed
+}
+
+func (store *AuthCacheStore) runWriter() {
+	defer close(store.doneChan)
+
+	for range store.wakeChan {
+		pending, clear, closed := store.takePending()
+		store.write(pending, clear)
+
+		if closed {
+			return
+		}
+	}
+}
+
+// write applies the writes, a clear is applied first
+func (store *AuthCacheStore) write(pending map[string]*AuthCacheEntry, clear bool) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "AuthCacheStore",
+		"function": "write",
+	})
+
+	if clear {
+		err := store.db.Update(func(tx *bolt.Tx) error {
+			err := tx.DeleteBucket([]byte(authCacheStoreBucket))
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.CreateBucket([]byte(authCacheStoreBucket))
+			return err
+		})
+		if err != nil {
+			logger.WithError(err).Error("failed to clear auth cache entries")
+		}
+	}
+
+	for dn, entry := range pending {
+		err := store.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(authCacheStoreBucket))
+			if entry == nil {
+				return bucket.Delete([]byte(dn))
+			}
+
+			data, err := store.encrypt(entry)
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte(dn), data)
+		})
+		if err != nil {
+			logger.WithError(err).Errorf("failed to write auth cache entry of %s", dn)
+		}
+	}
+}
+
+// encrypt serializes and encrypts the entry, DN is authenticated as additional data
+func (store *AuthCacheStore) encrypt(entry *AuthCacheEntry) ([]byte, error) {
+	plaintext, err := json.Marshal(&persistedAuthCacheEntry{
+		Entry:    entry,
+		Verifier: entry.Verifier,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, store.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.aead.Seal(nonce, nonce, plaintext, []byte(entry.DN)), nil
+}
+
+func (store *AuthCacheStore) decrypt(dn []byte, data []byte) (*AuthCacheEntry, error) {
+	nonceSize := store.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("data is too short")
+	}
+
+	plaintext, err := store.aead.Open(nil, data[:nonceSize], data[nonceSize:], dn)
+	if err != nil {
+		return nil, err
+	}
+
+	persisted := persistedAuthCacheEntry{}
+	err = json.Unmarshal(plaintext, &persisted)
+	if err != nil {
+		return nil, err
+	}
+
+	if persisted.Entry == nil || persisted.Entry.DN != string(dn) {
+		return nil, fmt.Errorf("malformed entry")
+	}
+
+	persisted.Entry.Verifier = persisted.Verifier
+	return persisted.Entry, nil
+}
@@ -0,0 +1,230 @@
+package ldap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+const (
+	testAuthCacheKey string = "0123456789abcdef0123456789abcdef"
+)
+
+// newTestAuthCacheStoreDir creates a temp dir with a key file for stores
+func newTestAuthCacheStoreDir(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	return dir, writeTestKeyFile(t, dir, testAuthCacheKey)
+}
+
+// openTestAuthCacheStore opens the store in the dir
+func openTestAuthCacheStore(t *testing.T, dir string, keyFilePath string) *AuthCacheStore {
+	store, err := NewAuthCacheStore(filepath.Join(dir, "auth_cache.db"), keyFilePath)
+	if err != nil {
+		t.Fatalf("failed to open auth cache store - %v", err)
+	}
+	return store
+}
+
+// loadTestAuthCacheStoreDNs reopens the store in the dir and returns DNs of the entries loaded, sorted
+func loadTestAuthCacheStoreDNs(t *testing.T, dir string, keyFilePath string) []string {
+	store := openTestAuthCacheStore(t, dir, keyFilePath)
+	defer store.Close()
+
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatalf("failed to load auth cache entries - %v", err)
+	}
+
+	dns := []string{}
+	for _, entry := range entries {
+		dns = append(dns, entry.DN)
+	}
+	sort.Strings(dns)
+	return dns
+}
+
+func TestAuthCacheStoreRoundTrip(t *testing.T) {
+	dir, keyFilePath := newTestAuthCacheStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	entry := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	entry.Verifier = &CredentialVerifier{
+		Salt:    []byte("salt"),
+		Hash:    []byte("hash"),
+		Time:    1,
+		Memory:  64,
+		Threads: 1,
+	}
+
+	store := openTestAuthCacheStore(t, dir, keyFilePath)
+	store.Save(entry)
+	store.Close()
+
+	store = openTestAuthCacheStore(t, dir, keyFilePath)
+	defer store.Close()
+
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatalf("failed to load auth cache entries - %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+
+	loaded := entries[0]
+	if loaded.DN != entry.DN || loaded.Username != entry.Username || loaded.Zone != entry.Zone || loaded.UserType != entry.UserType {
+		t.Errorf("expected %+v, got %+v", entry, loaded)
+	}
+
+	if len(loaded.Groups) != 1 || loaded.Groups[0] != "public" {
+		t.Errorf("unexpected groups %v", loaded.Groups)
+	}
+
+	if !loaded.Verified.Equal(entry.Verified) || !loaded.Expires.Equal(entry.Expires) {
+		t.Errorf("unexpected times, verified %s, expires %s", loaded.Verified.String(), loaded.Expires.String())
+	}
+
+	if loaded.Verifier == nil || string(loaded.Verifier.Salt) != "salt" || string(loaded.Verifier.Hash) != "hash" || loaded.Verifier.Memory != 64 {
+		t.Errorf("unexpected verifier %+v", loaded.Verifier)
+	}
+}
+
+func TestAuthCacheStoreDecrypt(t *testing.T) {
+	dir, keyFilePath := newTestAuthCacheStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	store := openTestAuthCacheStore(t, dir, keyFilePath)
+	defer store.Close()
+
+	entry := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	data, err := store.encrypt(entry)
+	if err != nil {
+		t.Fatalf("failed to encrypt - %v", err)
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name  string
+		dn    string
+		data  []byte
+		valid bool
+	}{
+		{"same DN", entry.DN, data, true},
+		// an entry moved under another DN must not authenticate the other DN
+		{"other DN", "uid=bob,ou=People,dc=example,dc=org", data, false},
+		{"tampered", entry.DN, tampered, false},
+		{"truncated", entry.DN, data[:4], false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := store.decrypt([]byte(test.dn), test.data)
+			if valid := err == nil; valid != test.valid {
+				t.Errorf("expected valid %v, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestAuthCacheStoreWrongKey(t *testing.T) {
+	dir, keyFilePath := newTestAuthCacheStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	store := openTestAuthCacheStore(t, dir, keyFilePath)
+	store.Save(newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute))
+	store.Close()
+
+	// entries that cannot be decrypted are skipped
+	otherKeyFilePath := filepath.Join(dir, "other.key")
+	err := ioutil.WriteFile(otherKeyFilePath, []byte("fedcba9876543210fedcba9876543210"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write key file - %v", err)
+	}
+
+	if dns := loadTestAuthCacheStoreDNs(t, dir, otherKeyFilePath); len(dns) != 0 {
+		t.Errorf("expected no entries with a wrong key, got %v", dns)
+	}
+}
+
+func TestAuthCacheStoreRemovals(t *testing.T) {
+	dir, keyFilePath := newTestAuthCacheStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	alice := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	bob := newTestAuthCacheEntry("uid=bob,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	carol := newTestAuthCacheEntry("uid=carol,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+
+	store := openTestAuthCacheStore(t, dir, keyFilePath)
+	store.Save(alice)
+	store.Save(bob)
+	store.Close()
+
+	store = openTestAuthCacheStore(t, dir, keyFilePath)
+	store.Delete(alice.DN)
+	store.Close()
+
+	dns := loadTestAuthCacheStoreDNs(t, dir, keyFilePath)
+	if len(dns) != 1 || dns[0] != bob.DN {
+		t.Errorf("expected only %s left, got %v", bob.DN, dns)
+	}
+
+	// writes queued before a clear are discarded, writes after are kept
+	store = openTestAuthCacheStore(t, dir, keyFilePath)
+	store.Save(alice)
+	store.Clear()
+	store.Save(carol)
+	store.Close()
+
+	dns = loadTestAuthCacheStoreDNs(t, dir, keyFilePath)
+	if len(dns) != 1 || dns[0] != carol.DN {
+		t.Errorf("expected only %s left, got %v", carol.DN, dns)
+	}
+}
+
+func TestAuthCacheStoreQueueFull(t *testing.T) {
+	dir, keyFilePath := newTestAuthCacheStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	alice := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	bob := newTestAuthCacheEntry("uid=bob,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+
+	store := openTestAuthCacheStore(t, dir, keyFilePath)
+	store.Save(bob)
+	store.Close()
+
+	store = openTestAuthCacheStore(t, dir, keyFilePath)
+
+	// fill the queue without waking the writer
+	store.pendingMutex.Lock()
+	for i := 0; i < authCacheStoreQueueSize; i++ {
+		dn := fmt.Sprintf("uid=user%d,ou=People,dc=example,dc=org", i)
+		store.pending[dn] = newTestAuthCacheEntry(dn, -time.Minute, -time.Minute, 4*time.Minute)
+	}
+	store.pendingMutex.Unlock()
+
+	// a save is dropped, but a removal is not
+	store.Save(alice)
+	store.Delete(bob.DN)
+	store.Close()
+
+	dns := loadTestAuthCacheStoreDNs(t, dir, keyFilePath)
+	if len(dns) != authCacheStoreQueueSize {
+		t.Errorf("expected %d entries, got %d", authCacheStoreQueueSize, len(dns))
+	}
+
+	for _, dn := range dns {
+		if dn == alice.DN || dn == bob.DN {
+			t.Errorf("unexpected entry %s", dn)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// newTestAuthCache creates an AuthCache without a janitor and a store, TTLs are in seconds
+func newTestAuthCache(t *testing.T, ttl int, maxLifetime int, sliding bool, maxEntries int) *AuthCache {
+	config := commons.NewDefaultConfig()
+	config.AuthCacheTimeout = ttl
+	config.AuthCacheMaxLifetime = maxLifetime
+	config.AuthCacheSliding = sliding
+	config.AuthCacheMaxEntries = maxEntries
+	config.AuthCacheCleanupInterval = 0
+	config.AuthGracePeriod = 0
+
+	cache, err := NewAuthCache(config)
+	if err != nil {
+		t.Fatalf("failed to create an auth cache - %v", err)
+	}
+	return cache
+}
+
+// newTestAuthCacheEntry makes an entry of the DN verified, used and expiring at the given offsets from now
+func newTestAuthCacheEntry(dn string, verified time.Duration, used time.Duration, expires time.Duration) *AuthCacheEntry {
+	now := time.Now()
+	return &AuthCacheEntry{
+		DN:       dn,
+		Username: "alice",
+		Zone:     "iplant",
+		UserType: "rodsuser",
+		Groups:   []string{"public"},
+		Verifier: &CredentialVerifier{},
+		Verified: now.Add(verified),
+		LastUsed: now.Add(used),
+		Expires:  now.Add(expires),
+	}
+}
+
+func TestAuthCacheExpiry(t *testing.T) {
+	verified := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		ttl         int
+		maxLifetime int
+		used        time.Duration // since verified
+		expected    time.Duration // since verified
+	}{
+		{"TTL", 300, 3600, 0, 5 * time.Minute},
+		{"TTL from use", 300, 3600, 10 * time.Minute, 15 * time.Minute},
+		{"capped by max lifetime", 300, 3600, 58 * time.Minute, time.Hour},
+		{"no max lifetime", 300, 0, 2 * time.Hour, 2*time.Hour + 5*time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := newTestAuthCache(t, test.ttl, test.maxLifetime, true, 0)
+			defer cache.Release()
+
+			actual := cache.getExpiry(verified, verified.Add(test.used))
+			if !actual.Equal(verified.Add(test.expected)) {
+				t.Errorf("expected expiry %s after verification, got %s", test.expected.String(), actual.Sub(verified).String())
+			}
+		})
+	}
+}
+
+func TestAuthCacheGet(t *testing.T) {
+	cache := newTestAuthCache(t, 300, 3600, false, 0)
+	defer cache.Release()
+
+	cache.Put(newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute))
+	cache.Put(newTestAuthCacheEntry("uid=bob,ou=People,dc=example,dc=org", -10*time.Minute, -10*time.Minute, -5*time.Minute))
+
+	entry, ok := cache.Get("uid=alice,ou=People,dc=example,dc=org")
+	if !ok || entry.Username != "alice" {
+		t.Errorf("unexpired entry must be returned, got %+v", entry)
+	}
+
+	// returned entries are copies
+	entry.Groups = nil
+	entry.Username = "mallory"
+	if entry, _ := cache.Get("uid=alice,ou=People,dc=example,dc=org"); entry.Username != "alice" {
+		t.Error("cached entry must not be changed through a returned entry")
+	}
+
+	if _, ok := cache.Get("uid=bob,ou=People,dc=example,dc=org"); ok {
+		t.Error("expired entry must not be returned")
+	}
+
+	if _, ok := cache.Get("uid=carol,ou=People,dc=example,dc=org"); ok {
+		t.Error("unknown DN must not be returned")
+	}
+
+	// expired entries are removed on access without a grace period
+	if entries := cache.ListAll(); len(entries) != 1 {
+		t.Errorf("expected 1 entry left, got %d", len(entries))
+	}
+}
+
+func TestAuthCacheUse(t *testing.T) {
+	tests := []struct {
+		name            string
+		sliding         bool
+		verified        time.Duration
+		expires         time.Duration
+		expectedExpires time.Duration
+	}{
+		{"fixed expiry", false, -time.Minute, 4 * time.Minute, 4 * time.Minute},
+		{"sliding expiry", true, -time.Minute, 4 * time.Minute, 5 * time.Minute},
+		{"sliding capped by max lifetime", true, -58 * time.Minute, 4 * time.Minute, 2 * time.Minute},
+		{"expired entry not renewed", true, -10 * time.Minute, -5 * time.Minute, -5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := newTestAuthCache(t, 300, 3600, test.sliding, 0)
+			defer cache.Release()
+
+			entry := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", test.verified, test.verified, test.expires)
+			cache.Put(entry)
+
+			now := time.Now()
+			cache.Use(entry.DN, entry.Verifier)
+
+			actual := entry.Expires.Sub(now)
+			if diff := actual - test.expectedExpires; diff < -time.Second || diff > time.Second {
+				t.Errorf("expected to expire in %s, got %s", test.expectedExpires.String(), actual.String())
+			}
+		})
+	}
+}
+
+func TestAuthCacheUseReplacedEntry(t *testing.T) {
+	cache := newTestAuthCache(t, 300, 3600, true, 0)
+	defer cache.Release()
+
+	oldEntry := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	cache.Put(oldEntry)
+
+	newEntry := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	cache.Put(newEntry)
+	expires := newEntry.Expires
+
+	cache.Use(oldEntry.DN, oldEntry.Verifier)
+	if !newEntry.Expires.Equal(expires) {
+		t.Error("entry must not be renewed with a verifier it was replaced from")
+	}
+
+	if cache.DeleteEntry(oldEntry.DN, oldEntry.Verifier) {
+		t.Error("entry must not be deleted with a verifier it was replaced from")
+	}
+
+	if !cache.DeleteEntry(newEntry.DN, newEntry.Verifier) {
+		t.Error("entry must be deleted with its verifier")
+	}
+}
+
+func TestAuthCacheUseStoredVerifier(t *testing.T) {
+	cache := newTestAuthCache(t, 300, 3600, false, 0)
+	defer cache.Release()
+
+	// as loaded from the store, only the argon2id hash is kept
+	entry := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	entry.Verifier = &CredentialVerifier{Salt: []byte("salt"), Hash: []byte("hash")}
+	cache.Put(entry)
+
+	upgradedVerifier := *entry.Verifier
+	upgradedVerifier.MAC = []byte("mac")
+	cache.Use(entry.DN, &upgradedVerifier)
+
+	if actual, ok := cache.Get(entry.DN); !ok || actual.Verifier != &upgradedVerifier {
+		t.Error("stored verifier must be replaced with the one with a MAC")
+	}
+
+	// later deleted with the verifier it was read with
+	if !cache.DeleteEntry(entry.DN, &upgradedVerifier) {
+		t.Error("entry must be deleted with its verifier")
+	}
+}
+
+func TestAuthCacheLRU(t *testing.T) {
+	cache := newTestAuthCache(t, 300, 3600, false, 2)
+	defer cache.Release()
+
+	alice := newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	bob := newTestAuthCacheEntry("uid=bob,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+	carol := newTestAuthCacheEntry("uid=carol,ou=People,dc=example,dc=org", -time.Minute, -time.Minute, 4*time.Minute)
+
+	cache.Put(alice)
+	cache.Put(bob)
+
+	// alice becomes the most recently used, bob is evicted
+	cache.Use(alice.DN, alice.Verifier)
+	cache.Put(carol)
+
+	expected := []string{carol.DN, alice.DN}
+	entries := cache.List()
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(entries))
+	}
+
+	for i, dn := range expected {
+		if entries[i].DN != dn {
+			t.Errorf("entry %d - expected %s, got %s", i, dn, entries[i].DN)
+		}
+	}
+
+	// shrinking evicts least recently used entries
+	config := commons.NewDefaultConfig()
+	config.AuthCacheTimeout = 300
+	config.AuthCacheMaxLifetime = 3600
+	config.AuthCacheMaxEntries = 1
+	cache.Reconfigure(config)
+
+	if _, ok := cache.Get(carol.DN); !ok || len(cache.List()) != 1 {
+		t.Error("only the most recently used entry must be left")
+	}
+}
+
+func TestAuthCacheReconfigure(t *testing.T) {
+	cache := newTestAuthCache(t, 300, 3600, false, 0)
+	defer cache.Release()
+
+	cache.Put(newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -2*time.Minute, -2*time.Minute, 3*time.Minute))
+
+	config := commons.NewDefaultConfig()
+	config.AuthCacheTimeout = 60
+	config.AuthCacheMaxLifetime = 3600
+	cache.Reconfigure(config)
+
+	// shortened to the new TTL from the last use
+	if _, ok := cache.Get("uid=alice,ou=People,dc=example,dc=org"); ok {
+		t.Error("entry must expire with the new TTL")
+	}
+}
+
+func TestAuthCacheDelete(t *testing.T) {
+	cache := newTestAuthCache(t, 300, 3600, false, 0)
+	defer cache.Release()
+
+	tests := []struct {
+		dn     string
+		groups []string
+	}{
+		{"uid=alice,ou=People,dc=example,dc=org", []string{"public", "staff"}},
+		{"uid=bob,ou=People,dc=example,dc=org", []string{"public"}},
+		{"uid=carol,ou=People,dc=other,dc=org", []string{"public", "staff"}},
+		{"uid=dave,ou=People,dc=other,dc=org", []string{"public"}},
+	}
+
+	for _, test := range tests {
+		entry := newTestAuthCacheEntry(test.dn, -time.Minute, -time.Minute, 4*time.Minute)
+		entry.Groups = test.groups
+		cache.Put(entry)
+	}
+
+	if deleted := cache.DeleteByGroup("staff"); deleted != 2 {
+		t.Errorf("expected 2 entries of the group removed, got %d", deleted)
+	}
+
+	if deleted := cache.DeleteUnderBaseDN("dc=other,dc=org"); deleted != 1 {
+		t.Errorf("expected 1 entry under the base DN removed, got %d", deleted)
+	}
+
+	if !cache.Delete("uid=bob,ou=People,dc=example,dc=org") {
+		t.Error("entry of the DN must be removed")
+	}
+
+	if cache.Delete("uid=bob,ou=People,dc=example,dc=org") {
+		t.Error("entry must not be removed twice")
+	}
+
+	if flushed := cache.Flush(); flushed != 0 {
+		t.Errorf("expected no entries left, flushed %d", flushed)
+	}
+}
+
+func TestAuthCacheGrace(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.AuthCacheTimeout = 300
+	config.AuthCacheMaxLifetime = 3600
+	config.AuthCacheCleanupInterval = 0
+	config.AuthGracePeriod = 60 * 60 * 24
+
+	cache, err := NewAuthCache(config)
+	if err != nil {
+		t.Fatalf("failed to create an auth cache - %v", err)
+	}
+	defer cache.Release()
+
+	tests := []struct {
+		name     string
+		verified time.Duration
+		expires  time.Duration
+		hit      bool
+		grace    bool
+	}{
+		{"unexpired", -time.Minute, 4 * time.Minute, true, true},
+		{"expired within grace", -2 * time.Hour, -time.Hour, false, true},
+		{"expired after grace", -25 * time.Hour, -24 * time.Hour, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dn := "uid=alice,ou=People,dc=example,dc=org"
+			cache.Put(newTestAuthCacheEntry(dn, test.verified, test.verified, test.expires))
+
+			if _, ok := cache.Get(dn); ok != test.hit {
+				t.Errorf("expected hit %v, got %v", test.hit, ok)
+			}
+
+			if _, ok := cache.GetGrace(dn); ok != test.grace {
+				t.Errorf("expected grace hit %v, got %v", test.grace, ok)
+			}
+		})
+	}
+
+	// retained for the grace period, but not listed as valid
+	cache.Flush()
+	cache.Put(newTestAuthCacheEntry("uid=alice,ou=People,dc=example,dc=org", -2*time.Hour, -2*time.Hour, -time.Hour))
+	cache.Put(newTestAuthCacheEntry("uid=bob,ou=People,dc=example,dc=org", -25*time.Hour, -25*time.Hour, -24*time.Hour))
+	cache.deleteExpired()
+
+	if len(cache.List()) != 0 || len(cache.ListAll()) != 1 {
+		t.Errorf("expected 1 entry retained, got %d listed and %d retained", len(cache.List()), len(cache.ListAll()))
+	}
+
+	// grace mode is off without a grace period
+	config.AuthGracePeriod = 0
+	cache.Reconfigure(config)
+	if _, ok := cache.GetGrace("uid=alice,ou=People,dc=example,dc=org"); ok {
+		t.Error("expired entry must not be used without a grace period")
+	}
+}
@@ -0,0 +1,244 @@
+package ldap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	gocache "github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	authFailureKeyPrefixDN string = "dn:"
+	authFailureKeyPrefixIP string = "ip:"
+
+	// expired failures are dropped at this interval, entries are set with TTLs of the config in effect
+	authGuardCleanupInterval time.Duration = time.Minute
+)
+
+// authFailure is a failure counter for a DN or a source IP
+type authFailure struct {
+	Count       int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// AuthLockout is a lockout status of a DN or a source IP
+type AuthLockout struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// AuthGuardStats holds counters of AuthGuard
+type AuthGuardStats struct {
+	NegativeCacheEntries int    `json:"negative_cache_entries"`
+	NegativeCacheHits    uint64 `json:"negative_cache_hits"`
+	LockoutRejects       uint64 `json:"lockout_rejects"`
+	Lockouts             uint64 `json:"lockouts"`
+}
+
+// AuthGuard remembers failed binds and locks out DNs and source IPs
+// that fail repeatedly, so they do not reach iRODS
+type AuthGuard struct {
+	config        *commons.Config
+	hasher        *CredentialHasher
+	negativeCache *gocache.Cache
+	failures      *gocache.Cache
+	stats         AuthGuardStats
+	mutex         sync.Mutex
+}
+
+// NewAuthGuard creates a new AuthGuard
+func NewAuthGuard(config *commons.Config, hasher *CredentialHasher) *AuthGuard {
+	return &AuthGuard{
+		config:        config,
+		hasher:        hasher,
+		negativeCache: gocache.New(gocache.NoExpiration, authGuardCleanupInterval),
+		failures:      gocache.New(gocache.NoExpiration, authGuardCleanupInterval),
+	}
+}
+
+// Reconfigure applies the negative cache timeout, failure window and lockout settings of the config.
+// Failures recorded so far are kept, remembered failed credentials are dropped if the negative cache is disabled
+func (guard *AuthGuard) Reconfigure(config *commons.Config) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	guard.config = config
+	if config.AuthNegativeCacheTimeout <= 0 {
+		guard.negativeCache.Flush()
+	}
+}
+
+// Check checks if the bind is allowed to be verified
+func (guard *AuthGuard) Check(dn string, password string, clientIP string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "AuthGuard",
+		"function": "Check",
+	})
+
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range guard.failureKeys(dn, clientIP) {
+		if failure, ok := guard.getFailure(key); ok {
+			if now.Before(failure.LockedUntil) {
+				guard.stats.LockoutRejects++
+				logger.Warnf("rejecting bind of %s from %s, %s is locked out until %s", dn, clientIP, key, failure.LockedUntil.Format(time.RFC3339))
+				return fmt.Errorf("%s is locked out until %s", key, failure.LockedUntil.Format(time.RFC3339))
+			}
+		}
+	}
+
+	if guard.config.AuthNegativeCacheTimeout > 0 {
+		if _, ok := guard.negativeCache.Get(guard.negativeCacheKey(dn, password)); ok {
+			guard.stats.NegativeCacheHits++
+			guard.recordFailure(dn, clientIP)
+			return fmt.Errorf("credential for %s failed recently", dn)
+		}
+	}
+
+	return nil
+}
+
+// Fail records a failed bind
+func (guard *AuthGuard) Fail(dn string, password string, clientIP string) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	if guard.config.AuthNegativeCacheTimeout > 0 {
+		guard.negativeCache.Set(guard.negativeCacheKey(dn, password), true, time.Duration(guard.config.AuthNegativeCacheTimeout)*time.Second)
+	}
+
+	guard.recordFailure(dn, clientIP)
+}
+
+// Succeed records a successful bind, clearing the DN failure counter
+func (guard *AuthGuard) Succeed(dn string, clientIP string) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	guard.failures.Delete(authFailureKeyPrefixDN + dn)
+}
+
+// ListLockouts returns DNs and source IPs currently locked out
+func (guard *AuthGuard) ListLockouts() []AuthLockout {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	now := time.Now()
+	lockouts := []AuthLockout{}
+	for key, item := range guard.failures.Items() {
+		if failure, ok := item.Object.(*authFailure); ok {
+			if now.Before(failure.LockedUntil) {
+				lockouts = append(lockouts, AuthLockout{
+					Key:         key,
+					Failures:    failure.Count,
+					LastFailure: failure.LastFailure,
+					LockedUntil: failure.LockedUntil,
+				})
+			}
+		}
+	}
+	return lockouts
+}
+
+// GetStats returns counters
+func (guard *AuthGuard) GetStats() AuthGuardStats {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	stats := guard.stats
+	stats.NegativeCacheEntries = guard.negativeCache.ItemCount()
+	return stats
+}
+
+func (guard *AuthGuard) failureKeys(dn string, clientIP string) []string {
+	keys := []string{authFailureKeyPrefixDN + dn}
+	if len(clientIP) > 0 {
+		keys = append(keys, authFailureKeyPrefixIP+clientIP)
+	}
+	return keys
+}
+
+func (guard *AuthGuard) negativeCacheKey(dn string, password string) string {
+	return guard.hasher.MakeKey(dn, password)
+}
+
+func (guard *AuthGuard) getFailure(key string) (*authFailure, bool) {
+	item, ok := guard.failures.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	failure, ok := item.(*authFailure)
+	return failure, ok
+}
+
+// recordFailure increases failure counters, the caller must hold the mutex
+func (guard *AuthGuard) recordFailure(dn string, clientIP string) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "AuthGuard",
+		"function": "recordFailure",
+	})
+
+	now := time.Now()
+	for _, key := range guard.failureKeys(dn, clientIP) {
+		threshold := guard.config.AuthLockoutThresholdDN
+		if key != authFailureKeyPrefixDN+dn {
+			threshold = guard.config.AuthLockoutThresholdIP
+		}
+
+		if threshold <= 0 {
+			// disabled
+			continue
+		}
+
+		failure, ok := guard.getFailure(key)
+		if !ok {
+			failure = &authFailure{}
+		}
+
+		failure.Count++
+		failure.LastFailure = now
+
+		expiration := time.Duration(guard.config.AuthFailureWindow) * time.Second
+		if failure.Count >= threshold {
+			lockout := guard.getLockoutDuration(failure.Count - threshold)
+			failure.LockedUntil = now.Add(lockout)
+			if lockout > expiration {
+				expiration = lockout
+			}
+
+			guard.stats.Lockouts++
+			logger.Warnf("locking out %s for %s after %d failed binds", key, lockout.String(), failure.Count)
+		}
+
+		guard.failures.Set(key, failure, expiration)
+	}
+}
+
+// getLockoutDuration returns exponentially growing lockout duration
+func (guard *AuthGuard) getLockoutDuration(exceeded int) time.Duration {
+	lockoutMax := time.Duration(guard.config.AuthLockoutMax) * time.Second
+	lockout := time.Duration(guard.config.AuthLockoutBase) * time.Second
+
+	for i := 0; i < exceeded; i++ {
+		lockout *= 2
+		if lockout >= lockoutMax {
+			return lockoutMax
+		}
+	}
+
+	if lockout > lockoutMax {
+		return lockoutMax
+	}
+	return lockout
+}
@@ -0,0 +1,163 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+const (
+	testGuardDN string = "uid=alice,ou=People,dc=example,dc=org"
+)
+
+// newTestAuthGuard creates an AuthGuard with the negative cache disabled, to count failures only
+func newTestAuthGuard(t *testing.T, thresholdDN int, thresholdIP int) *AuthGuard {
+	config := commons.NewDefaultConfig()
+	config.AuthNegativeCacheTimeout = 0
+	config.AuthLockoutThresholdDN = thresholdDN
+	config.AuthLockoutThresholdIP = thresholdIP
+	config.AuthLockoutBase = 30
+	config.AuthLockoutMax = 60 * 15
+	return NewAuthGuard(config, newTestHasher(t))
+}
+
+func TestAuthGuardLockout(t *testing.T) {
+	tests := []struct {
+		name        string
+		thresholdDN int
+		thresholdIP int
+		failures    int
+		checkDN     string
+		checkIP     string
+		locked      bool
+	}{
+		{"under DN threshold", 3, 0, 2, testGuardDN, "10.0.0.1", false},
+		{"at DN threshold", 3, 0, 3, testGuardDN, "10.0.0.1", true},
+		{"DN locked from other IP", 3, 0, 3, testGuardDN, "10.0.0.2", true},
+		{"other DN not locked", 3, 0, 3, "uid=bob,ou=People,dc=example,dc=org", "10.0.0.1", false},
+		{"at IP threshold", 0, 3, 3, testGuardDN, "10.0.0.1", true},
+		{"IP locked for other DN", 0, 3, 3, "uid=bob,ou=People,dc=example,dc=org", "10.0.0.1", true},
+		{"other IP not locked", 0, 3, 3, testGuardDN, "10.0.0.2", false},
+		{"disabled", 0, 0, 100, testGuardDN, "10.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			guard := newTestAuthGuard(t, test.thresholdDN, test.thresholdIP)
+			for i := 0; i < test.failures; i++ {
+				guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+			}
+
+			err := guard.Check(test.checkDN, "secret", test.checkIP)
+			if locked := err != nil; locked != test.locked {
+				t.Errorf("expected locked %v, got error %v", test.locked, err)
+			}
+		})
+	}
+}
+
+func TestAuthGuardSucceedClearsDN(t *testing.T) {
+	guard := newTestAuthGuard(t, 3, 0)
+
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+	guard.Succeed(testGuardDN, "10.0.0.1")
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+
+	err := guard.Check(testGuardDN, "secret", "10.0.0.1")
+	if err != nil {
+		t.Errorf("failures before a success must not count - %v", err)
+	}
+}
+
+func TestAuthGuardNegativeCache(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.AuthNegativeCacheTimeout = 30
+	config.AuthLockoutThresholdDN = 0
+	config.AuthLockoutThresholdIP = 0
+	guard := NewAuthGuard(config, newTestHasher(t))
+
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+
+	if err := guard.Check(testGuardDN, "wrong", "10.0.0.2"); err == nil {
+		t.Error("credential failed recently must be rejected")
+	}
+
+	if err := guard.Check(testGuardDN, "secret", "10.0.0.1"); err != nil {
+		t.Errorf("other password must be checked - %v", err)
+	}
+
+	stats := guard.GetStats()
+	if stats.NegativeCacheEntries != 1 || stats.NegativeCacheHits != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAuthGuardReconfigure(t *testing.T) {
+	guard := newTestAuthGuard(t, 3, 0)
+
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+	if err := guard.Check(testGuardDN, "wrong", "10.0.0.1"); err != nil {
+		t.Fatalf("expected no lockout under the threshold - %v", err)
+	}
+
+	// failures so far count towards the new threshold
+	config := commons.NewDefaultConfig()
+	config.AuthNegativeCacheTimeout = 0
+	config.AuthLockoutThresholdDN = 1
+	config.AuthLockoutThresholdIP = 0
+	guard.Reconfigure(config)
+
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+	if err := guard.Check(testGuardDN, "wrong", "10.0.0.1"); err == nil {
+		t.Error("expected a lockout with the new threshold")
+	}
+}
+
+func TestAuthGuardListLockouts(t *testing.T) {
+	guard := newTestAuthGuard(t, 2, 0)
+
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+	if lockouts := guard.ListLockouts(); len(lockouts) != 0 {
+		t.Errorf("expected no lockouts, got %+v", lockouts)
+	}
+
+	guard.Fail(testGuardDN, "wrong", "10.0.0.1")
+	lockouts := guard.ListLockouts()
+	if len(lockouts) != 1 {
+		t.Fatalf("expected a lockout, got %+v", lockouts)
+	}
+
+	if lockouts[0].Key != authFailureKeyPrefixDN+testGuardDN || lockouts[0].Failures != 2 {
+		t.Errorf("unexpected lockout %+v", lockouts[0])
+	}
+
+	guard.Check(testGuardDN, "secret", "10.0.0.1")
+	stats := guard.GetStats()
+	if stats.Lockouts != 1 || stats.LockoutRejects != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAuthGuardLockoutDuration(t *testing.T) {
+	guard := newTestAuthGuard(t, 1, 0)
+
+	tests := []struct {
+		exceeded int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 60 * time.Second},
+		{3, 240 * time.Second},
+		{5, 15 * time.Minute}, // capped
+		{100, 15 * time.Minute},
+	}
+
+	for _, test := range tests {
+		if actual := guard.getLockoutDuration(test.exceeded); actual != test.expected {
+			t.Errorf("exceeded %d - expected %s, got %s", test.exceeded, test.expected.String(), actual.String())
+		}
+	}
+}
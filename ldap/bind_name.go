@@ -0,0 +1,178 @@
+package ldap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// BindNameResolverUID resolves "uid=user[#zone],..." DNs
+	BindNameResolverUID string = "uid"
+	// BindNameResolverCN resolves "cn=user[#zone],..." DNs
+	BindNameResolverCN string = "cn"
+	// BindNameResolverUPN resolves "user@zone" names
+	BindNameResolverUPN string = "upn"
+	// BindNameResolverBare resolves bare "user[#zone]" names
+	BindNameResolverBare string = "bare"
+	// BindNameResolverRegex resolves names matching a regular expression with "user" and optional "zone" groups
+	BindNameResolverRegex string = "regex"
+)
+
+// BindNameResolver maps a bind name to an iRODS username and zone, zone is empty if the name does not tell
+type BindNameResolver interface {
+	Resolve(name string) (string, string, bool)
+}
+
+// NewBindNameResolvers creates resolvers by names, in order
+func NewBindNameResolvers(names []string, regex string) ([]BindNameResolver, error) {
+	resolvers := []BindNameResolver{}
+	for _, name := range names {
+		switch name {
+		case BindNameResolverUID, BindNameResolverCN:
+			resolvers = append(resolvers, &rdnBindNameResolver{key: name})
+		case BindNameResolverUPN:
+			resolvers = append(resolvers, &upnBindNameResolver{})
+		case BindNameResolverBare:
+			resolvers = append(resolvers, &bareBindNameResolver{})
+		case BindNameResolverRegex:
+			resolver, err := newRegexBindNameResolver(regex)
+			if err != nil {
+				return nil, err
+			}
+			resolvers = append(resolvers, resolver)
+		default:
+			return nil, fmt.Errorf("unknown bind name resolver %q", name)
+		}
+	}
+	return resolvers, nil
+}
+
+// IsDN checks if the bind name is a DN
+func IsDN(name string) bool {
+	return strings.Contains(name, "=")
+}
+
+// rdnBindNameResolver takes the username from an RDN of a DN
+type rdnBindNameResolver struct {
+	key string
+}
+
+func (resolver *rdnBindNameResolver) Resolve(name string) (string, string, bool) {
+	if !IsDN(name) {
+		return "", "", false
+	}
+
+	values := GetDNValues(name, resolver.key)
+	if len(values) == 0 || len(values[0]) == 0 {
+		return "", "", false
+	}
+
+	username, zone := SplitUsernameZone(values[0])
+	return username, zone, len(username) > 0
+}
+
+// upnBindNameResolver resolves "user@zone"
+type upnBindNameResolver struct{}
+
+func (resolver *upnBindNameResolver) Resolve(name string) (string, string, bool) {
+	if IsDN(name) {
+		return "", "", false
+	}
+
+	idx := strings.LastIndex(name, "@")
+	if idx <= 0 || idx == len(name)-1 {
+		return "", "", false
+	}
+	return name[:idx], name[idx+1:], true
+}
+
+// bareBindNameResolver resolves a bare username
+type bareBindNameResolver struct{}
+
+func (resolver *bareBindNameResolver) Resolve(name string) (string, string, bool) {
+	if IsDN(name) || strings.ContainsAny(name, "@,") {
+		return "", "", false
+	}
+
+	username, zone := SplitUsernameZone(name)
+	return username, zone, len(username) > 0
+}
+
+// regexBindNameResolver resolves names matching the expression
+type regexBindNameResolver struct {
+	expression *regexp.Regexp
+	userIndex  int
+	zoneIndex  int // -1 if there is no zone group
+}
+
+func newRegexBindNameResolver(expression string) (*regexBindNameResolver, error) {
+	compiled, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile bind name regex %q - %v", expression, err)
+	}
+
+	resolver := &regexBindNameResolver{
+		expression: compiled,
+		userIndex:  -1,
+		zoneIndex:  -1,
+	}
+
+	for idx, groupName := range compiled.SubexpNames() {
+		switch groupName {
+		case "user":
+			resolver.userIndex = idx
+		case "zone":
+			resolver.zoneIndex = idx
+		}
+	}
+
+	if resolver.userIndex < 0 {
+		return nil, fmt.Errorf("bind name regex %q must have a \"user\" group", expression)
+	}
+	return resolver, nil
+}
+
+func (resolver *regexBindNameResolver) Resolve(name string) (string, string, bool) {
+	match := resolver.expression.FindStringSubmatch(name)
+	if match == nil || len(match[resolver.userIndex]) == 0 {
+		return "", "", false
+	}
+
+	zone := ""
+	if resolver.zoneIndex >= 0 {
+		zone = match[resolver.zoneIndex]
+	}
+	return match[resolver.userIndex], zone, true
+}
+
+// ResolveBindName returns the username and zone of the bind name with the first resolver matched.
+// If the name does not tell the zone, it is taken from an "ou" RDN naming a known zone, and is the home zone otherwise
+func ResolveBindName(name string, resolvers []BindNameResolver, zones map[string]*IRODSZone, homeZone string) (string, *IRODSZone, error) {
+	for _, resolver := range resolvers {
+		username, zoneName, ok := resolver.Resolve(name)
+		if !ok {
+			continue
+		}
+
+		if len(zoneName) == 0 {
+			zoneName = homeZone
+			if IsDN(name) {
+				for _, ou := range GetDNValues(name, "ou") {
+					if _, ok := zones[ou]; ok {
+						zoneName = ou
+						break
+					}
+				}
+			}
+		}
+
+		zone, ok := zones[zoneName]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown zone %q", zoneName)
+		}
+		return username, zone, nil
+	}
+
+	return "", nil, fmt.Errorf("no username in bind name %q", name)
+}
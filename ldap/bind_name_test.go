@@ -0,0 +1,120 @@
+package ldap
+
+import (
+	"testing"
+)
+
+func TestNewBindNameResolvers(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		regex string
+		valid bool
+	}{
+		{"all", []string{"uid", "cn", "upn", "bare", "regex"}, `^(?P<user>[^@]+)@example\.org$`, true},
+		{"none", []string{}, "", true},
+		{"unknown resolver", []string{"uid", "mail"}, "", false},
+		{"invalid regex", []string{"regex"}, `^(?P<user>[^@]+`, false},
+		{"regex without user group", []string{"regex"}, `^([^@]+)@example\.org$`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolvers, err := NewBindNameResolvers(test.names, test.regex)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+
+			if err == nil && len(resolvers) != len(test.names) {
+				t.Errorf("expected %d resolvers, got %d", len(test.names), len(resolvers))
+			}
+		})
+	}
+}
+
+func TestBindNameResolvers(t *testing.T) {
+	regexResolver, err := newRegexBindNameResolver(`^(?P<user>[^@]+)@(?P<zone>[a-z]+)\.example\.org$`)
+	if err != nil {
+		t.Fatalf("failed to create a regex resolver - %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		resolver BindNameResolver
+		bindName string
+		username string
+		zone     string
+		ok       bool
+	}{
+		{"uid", &rdnBindNameResolver{key: "uid"}, "uid=alice,ou=People,dc=example,dc=org", "alice", "", true},
+		{"uid with zone", &rdnBindNameResolver{key: "uid"}, "uid=alice#tempZone,ou=People,dc=example,dc=org", "alice", "tempZone", true},
+		{"uid not in DN", &rdnBindNameResolver{key: "uid"}, "cn=alice,ou=People,dc=example,dc=org", "", "", false},
+		{"uid empty", &rdnBindNameResolver{key: "uid"}, "uid=,ou=People,dc=example,dc=org", "", "", false},
+		{"uid not a DN", &rdnBindNameResolver{key: "uid"}, "alice", "", "", false},
+		{"cn", &rdnBindNameResolver{key: "cn"}, "cn=alice,ou=People,dc=example,dc=org", "alice", "", true},
+		{"upn", &upnBindNameResolver{}, "alice@tempZone", "alice", "tempZone", true},
+		{"upn last at", &upnBindNameResolver{}, "alice@home@tempZone", "alice@home", "tempZone", true},
+		{"upn without user", &upnBindNameResolver{}, "@tempZone", "", "", false},
+		{"upn without zone", &upnBindNameResolver{}, "alice@", "", "", false},
+		{"upn DN", &upnBindNameResolver{}, "uid=alice@tempZone,dc=example,dc=org", "", "", false},
+		{"bare", &bareBindNameResolver{}, "alice", "alice", "", true},
+		{"bare with zone", &bareBindNameResolver{}, "alice#tempZone", "alice", "tempZone", true},
+		{"bare with at", &bareBindNameResolver{}, "alice@tempZone", "", "", false},
+		{"bare DN", &bareBindNameResolver{}, "uid=alice,dc=example,dc=org", "", "", false},
+		{"regex", regexResolver, "alice@tempzone.example.org", "alice", "tempzone", true},
+		{"regex not matched", regexResolver, "alice@example.org", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			username, zone, ok := test.resolver.Resolve(test.bindName)
+			if ok != test.ok || username != test.username || zone != test.zone {
+				t.Errorf("expected (%q, %q, %v), got (%q, %q, %v)", test.username, test.zone, test.ok, username, zone, ok)
+			}
+		})
+	}
+}
+
+func TestResolveBindName(t *testing.T) {
+	zones := map[string]*IRODSZone{
+		"iplant":   {Name: "iplant", Host: "data.example.org", Port: 1247},
+		"tempZone": {Name: "tempZone", Host: "fed.example.org", Port: 1247},
+	}
+
+	resolvers, err := NewBindNameResolvers([]string{"uid", "upn", "bare"}, "")
+	if err != nil {
+		t.Fatalf("failed to create resolvers - %v", err)
+	}
+
+	tests := []struct {
+		bindName string
+		username string
+		zone     string
+		valid    bool
+	}{
+		{"uid=alice,ou=People,dc=example,dc=org", "alice", "iplant", true},
+		{"uid=alice#tempZone,ou=People,dc=example,dc=org", "alice", "tempZone", true},
+		// zone given by an "ou" RDN
+		{"uid=alice,ou=tempZone,ou=People,dc=example,dc=org", "alice", "tempZone", true},
+		{"uid=alice,ou=Staff,ou=People,dc=example,dc=org", "alice", "iplant", true},
+		{"alice@tempZone", "alice", "tempZone", true},
+		{"alice", "alice", "iplant", true},
+		{"alice@otherZone", "", "", false},
+		{"uid=alice#otherZone,ou=People,dc=example,dc=org", "", "", false},
+		// first resolver matched wins, cn is not enabled
+		{"cn=alice,ou=People,dc=example,dc=org", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.bindName, func(t *testing.T) {
+			username, zone, err := ResolveBindName(test.bindName, resolvers, zones, "iplant")
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+
+			if err == nil && (username != test.username || zone.Name != test.zone) {
+				t.Errorf("expected %s#%s, got %s#%s", test.username, test.zone, username, zone.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	credentialKeySize  int    = 32
+	credentialSaltSize int    = 16
+	credentialHashSize uint32 = 32
+)
+
+// CredentialVerifier is a verifier of a credential, it does not contain the password.
+// In memory, credentials are checked with a keyed hash under a random key of the process, which is fast.
+// Verifiers persisted with the auth cache also have a salted argon2id hash, as the process key is not kept
+type CredentialVerifier struct {
+	MAC     []byte `json:"-"` // empty for verifiers loaded from the store
+	Salt    []byte `json:"salt,omitempty"`
+	Hash    []byte `json:"hash,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+// IsSame checks if the verifiers are of the same verification, a verifier loaded from the store
+// is the same as the verifier with a MAC added after it matched
+func (verifier *CredentialVerifier) IsSame(other *CredentialVerifier) bool {
+	if verifier == other {
+		return true
+	}
+
+	if verifier == nil || other == nil || len(verifier.Hash) == 0 {
+		return false
+	}
+	return bytes.Equal(verifier.Hash, other.Hash) && bytes.Equal(verifier.Salt, other.Salt)
+}
+
+// CredentialHasher makes and checks credential verifiers.
+// Credentials are keyed with a random key generated per process before hashing,
+// so verifiers taken from a memory dump cannot be checked without the key
+type CredentialHasher struct {
+	processKey []byte // for MACs, never persisted
+	key        []byte // for argon2id hashes and lookup keys
+	persistent bool
+	time       uint32
+	memory     uint32
+	threads    uint8
+	kdfSlots   chan bool // caps argon2id computations running at once
+}
+
+// NewCredentialHasher creates a new CredentialHasher with a random key.
+// When the auth cache is persisted, the key of argon2id hashes is derived from the auth cache key file instead,
+// so persisted verifiers remain valid across restarts
+func NewCredentialHasher(config *commons.Config) (*CredentialHasher, error) {
+	processKey := make([]byte, credentialKeySize)
+	_, err := rand.Read(processKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a credential key - %v", err)
+	}
+
+	key := processKey
+	persistent := len(config.AuthCachePersistPath) > 0
+	if persistent {
+		derivedKey, err := readAuthCacheKey(config.AuthCacheKeyFilePath, authCacheCredentialKeyLabel)
+		if err != nil {
+			return nil, err
+		}
+		key = derivedKey
+	}
+
+	kdfConcurrency := config.AuthCacheKDFConcurrency
+	if kdfConcurrency <= 0 {
+		kdfConcurrency = 1
+	}
+
+	return &CredentialHasher{
+		processKey: processKey,
+		key:        key,
+		persistent: persistent,
+		time:       uint32(config.AuthCacheKDFTime),
+		memory:     uint32(config.AuthCacheKDFMemory),
+		threads:    uint8(config.AuthCacheKDFThreads),
+		kdfSlots:   make(chan bool, kdfConcurrency),
+	}, nil
+}
+
+// MakeKey returns a keyed hash of the credential, to be used as a lookup key.
+// This is fast, use MakeVerifier for values to be verified later
+func (hasher *CredentialHasher) MakeKey(dn string, password string) string {
+	return hex.EncodeToString(keyCredential(hasher.key, dn, password))
+}
+
+// MakeVerifier makes a new verifier of the credential, with a salted argon2id hash if the auth cache is persisted
+func (hasher *CredentialHasher) MakeVerifier(dn string, password string) (*CredentialVerifier, error) {
+	verifier := &CredentialVerifier{
+		MAC: keyCredential(hasher.processKey, dn, password),
+	}
+
+	if !hasher.persistent {
+		return verifier, nil
+	}
+
+	salt := make([]byte, credentialSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a salt - %v", err)
+	}
+
+	verifier.Salt = salt
+	verifier.Time = hasher.time
+	verifier.Memory = hasher.memory
+	verifier.Threads = hasher.threads
+	verifier.Hash = hasher.hash(verifier, dn, password)
+	return verifier, nil
+}
+
+// AddMAC returns a copy of the verifier with the MAC of the credential, for a verifier loaded from the store
+// that matched, so later checks are fast
+func (hasher *CredentialHasher) AddMAC(verifier *CredentialVerifier, dn string, password string) *CredentialVerifier {
+	verifierCopy := *verifier
+	verifierCopy.MAC = keyCredential(hasher.processKey, dn, password)
+	return &verifierCopy
+}
+
+// Verify checks if the credential matches the verifier, in constant time.
+// Verifiers loaded from the store are checked with argon2id, which is slow
+func (hasher *CredentialHasher) Verify(verifier *CredentialVerifier, dn string, password string) bool {
+	if verifier == nil {
+		return false
+	}
+
+	if len(verifier.MAC) > 0 {
+		return hmac.Equal(keyCredential(hasher.processKey, dn, password), verifier.MAC)
+	}
+
+	if len(verifier.Hash) == 0 {
+		return false
+	}
+
+	hash := hasher.hash(verifier, dn, password)
+	return subtle.ConstantTimeCompare(hash, verifier.Hash) == 1
+}
+
+// hash returns argon2id hash of the keyed credential with cost parameters of the verifier
+func (hasher *CredentialHasher) hash(verifier *CredentialVerifier, dn string, password string) []byte {
+	hasher.kdfSlots <- true
+	defer func() {
+		<-hasher.kdfSlots
+	}()
+
+	return argon2.IDKey(keyCredential(hasher.key, dn, password), verifier.Salt, verifier.Time, verifier.Memory, verifier.Threads, credentialHashSize)
+}
+
+// keyCredential returns HMAC of the credential with the key
+func keyCredential(key []byte, dn string, password string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(dn))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
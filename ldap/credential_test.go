@@ -0,0 +1,275 @@
+package ldap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// newTestHasher creates a CredentialHasher with cheap KDF parameters
+func newTestHasher(t *testing.T) *CredentialHasher {
+	config := commons.NewDefaultConfig()
+	config.AuthCacheKDFMemory = 64
+
+	hasher, err := NewCredentialHasher(config)
+	if err != nil {
+		t.Fatalf("failed to create a credential hasher - %v", err)
+	}
+	return hasher
+}
+
+// newTestPersistentHasher creates a CredentialHasher of a persisted auth cache in the dir, verifiers are hashed with argon2id
+func newTestPersistentHasher(t *testing.T, dir string) *CredentialHasher {
+	config := commons.NewDefaultConfig()
+	config.AuthCacheKDFMemory = 64
+	config.AuthCachePersistPath = filepath.Join(dir, "auth_cache.db")
+	config.AuthCacheKeyFilePath = writeTestKeyFile(t, dir, "0123456789abcdef0123456789abcdef\n")
+
+	hasher, err := NewCredentialHasher(config)
+	if err != nil {
+		t.Fatalf("failed to create a credential hasher - %v", err)
+	}
+	return hasher
+}
+
+// writeTestKeyFile writes an auth cache key file in a temp dir
+func writeTestKeyFile(t *testing.T, dir string, secret string) string {
+	path := filepath.Join(dir, "auth_cache.key")
+	err := ioutil.WriteFile(path, []byte(secret), 0600)
+	if err != nil {
+		t.Fatalf("failed to write key file - %v", err)
+	}
+	return path
+}
+
+func TestCredentialHasherVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hasher := newTestPersistentHasher(t, dir)
+
+	verifier, err := hasher.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	// as loaded from the store, checked with argon2id
+	storedVerifier := *verifier
+	storedVerifier.MAC = nil
+
+	tests := []struct {
+		name     string
+		dn       string
+		password string
+		expected bool
+	}{
+		{"same credential", "uid=alice,ou=People,dc=example,dc=org", "secret", true},
+		{"wrong password", "uid=alice,ou=People,dc=example,dc=org", "Secret", false},
+		{"empty password", "uid=alice,ou=People,dc=example,dc=org", "", false},
+		{"other DN", "uid=bob,ou=People,dc=example,dc=org", "secret", false},
+		// DN and password are separated, so they cannot be shifted
+		{"shifted boundary", "uid=alice,ou=People,dc=example,dc=orgs", "ecret", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := hasher.Verify(verifier, test.dn, test.password); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+
+			if actual := hasher.Verify(&storedVerifier, test.dn, test.password); actual != test.expected {
+				t.Errorf("expected %v for the stored verifier, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCredentialHasherVerifierParameters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hasher := newTestPersistentHasher(t, dir)
+
+	verifier1, err := hasher.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	verifier2, err := hasher.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	if string(verifier1.Salt) == string(verifier2.Salt) || string(verifier1.Hash) == string(verifier2.Hash) {
+		t.Error("verifiers of the same credential must be salted differently")
+	}
+
+	if verifier1.Time != 1 || verifier1.Memory != 64 || verifier1.Threads != 1 {
+		t.Errorf("unexpected KDF parameters %d/%d/%d", verifier1.Time, verifier1.Memory, verifier1.Threads)
+	}
+
+	// verifiers keep the parameters they were made with
+	verifier1.MAC = nil
+	verifier1.Memory = 128
+	if hasher.Verify(verifier1, "uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("verifier with altered parameters must not match")
+	}
+
+	if hasher.Verify(nil, "uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("nil verifier must not match")
+	}
+
+	if hasher.Verify(&CredentialVerifier{}, "uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("empty verifier must not match")
+	}
+}
+
+func TestCredentialHasherMAC(t *testing.T) {
+	hasher := newTestHasher(t)
+
+	verifier, err := hasher.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	// not persisted, argon2id is not needed
+	if len(verifier.MAC) == 0 || len(verifier.Salt) > 0 || len(verifier.Hash) > 0 {
+		t.Errorf("expected a verifier with a MAC only, got %+v", verifier)
+	}
+
+	if !hasher.Verify(verifier, "uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("verifier must match")
+	}
+}
+
+func TestCredentialVerifierAddMAC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hasher := newTestPersistentHasher(t, dir)
+
+	verifier, err := hasher.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	storedVerifier := *verifier
+	storedVerifier.MAC = nil
+
+	upgradedVerifier := hasher.AddMAC(&storedVerifier, "uid=alice,ou=People,dc=example,dc=org", "secret")
+	if len(storedVerifier.MAC) > 0 {
+		t.Error("stored verifier must not be changed")
+	}
+
+	if !hasher.Verify(upgradedVerifier, "uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("upgraded verifier must match")
+	}
+
+	otherVerifier, err := hasher.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		verifier *CredentialVerifier
+		other    *CredentialVerifier
+		expected bool
+	}{
+		{"same pointer", verifier, verifier, true},
+		{"stored copy", verifier, &storedVerifier, true},
+		{"upgraded", &storedVerifier, upgradedVerifier, true},
+		{"other salt", verifier, otherVerifier, false},
+		{"nil", verifier, nil, false},
+		{"without hashes", &CredentialVerifier{}, &CredentialVerifier{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.verifier.IsSame(test.other); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCredentialHasherKey(t *testing.T) {
+	hasher := newTestHasher(t)
+	otherHasher := newTestHasher(t)
+
+	key := hasher.MakeKey("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if key != hasher.MakeKey("uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("keys of the same credential must be equal")
+	}
+
+	if key == hasher.MakeKey("uid=alice,ou=People,dc=example,dc=org", "other") {
+		t.Error("keys of different passwords must differ")
+	}
+
+	// keyed per process
+	if key == otherHasher.MakeKey("uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("keys of different hashers must differ")
+	}
+
+	verifier, err := hasher.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	if otherHasher.Verify(verifier, "uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("verifier must not match with a different key")
+	}
+}
+
+func TestCredentialHasherPersistedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap-irods-auth-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := commons.NewDefaultConfig()
+	config.AuthCacheKDFMemory = 64
+	config.AuthCachePersistPath = filepath.Join(dir, "auth_cache.db")
+	config.AuthCacheKeyFilePath = writeTestKeyFile(t, dir, "0123456789abcdef0123456789abcdef\n")
+
+	hasher1, err := NewCredentialHasher(config)
+	if err != nil {
+		t.Fatalf("failed to create a credential hasher - %v", err)
+	}
+
+	// as after a restart
+	hasher2, err := NewCredentialHasher(config)
+	if err != nil {
+		t.Fatalf("failed to create a credential hasher - %v", err)
+	}
+
+	verifier, err := hasher1.MakeVerifier("uid=alice,ou=People,dc=example,dc=org", "secret")
+	if err != nil {
+		t.Fatalf("failed to make a verifier - %v", err)
+	}
+
+	// the MAC is not persisted
+	verifier.MAC = nil
+	if !hasher2.Verify(verifier, "uid=alice,ou=People,dc=example,dc=org", "secret") {
+		t.Error("verifier must match with the key derived from the same key file")
+	}
+
+	config.AuthCacheKeyFilePath = writeTestKeyFile(t, dir, "short")
+	_, err = NewCredentialHasher(config)
+	if err == nil {
+		t.Error("short key must be rejected")
+	}
+}
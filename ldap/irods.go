@@ -1,9 +1,9 @@
 package ldap
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	irodsclient_conn "github.com/cyverse/go-irodsclient/irods/connection"
@@ -11,98 +11,554 @@ import (
 	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
 	"github.com/cyverse/ldap-irods-auth/commons"
 	gocache "github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
-const (
-	applicationName    string        = "ldap-irods-auth"
-	authRequestTimeout time.Duration = 30 * time.Second
-	hashSeed           string        = "ldap-irods-auth-hash-seed1684165998778980816333407110267630157905106677102502820120929196213565346863704918937246704453519613"
-)
+// BindIdentity is a normalized identity of a bind name
+type BindIdentity struct {
+	DN       string // canonical DN, "uid=user[#zone],ou=People,<base DN>"
+	Username string
+	Zone     *IRODSZone
+}
 
 // IRODSAuth is a module for iRODS auth
 type IRODSAuth struct {
-	config    *commons.Config
-	authCache *gocache.Cache
+	config       *commons.Config
+	hasher       *CredentialHasher
+	authCache    *AuthCache
+	zones        map[string]*IRODSZone
+	resolvers    []BindNameResolver
+	normalizer   *UsernameNormalizer
+	emailCache   *gocache.Cache
+	policy       *UserPolicy     // replaced on reload
+	reloadConfig *commons.Config // reloadable settings other than the policy, replaced on reload
+	reloadMutex  sync.RWMutex
+	authGroup    singleflight.Group
+	pool         *IRODSConnectionPool // nil if the service account is not configured
+
+	irodsUnavailable  bool
+	availabilityMutex sync.Mutex
+
+	tracer AuthTracer // nil unless diagnosing
 }
 
-// NewLDAP creates a new LDAP service
-func NewIRODSAuth(config *commons.Config) (*IRODSAuth, error) {
-	timeout := time.Duration(config.AuthCacheTimeout) * time.Second
+// AuthTracer receives the result of each authentication step, for diagnostics
+type AuthTracer func(step string, result string, err error)
 
-	return &IRODSAuth{
-		config:    config,
-		authCache: gocache.New(timeout, timeout),
-	}, nil
+// NewIRODSAuth creates a new IRODSAuth for the naming context, the auth cache is shared between naming contexts
+func NewIRODSAuth(config *commons.Config, hasher *CredentialHasher, authCache *AuthCache) (*IRODSAuth, error) {
+	resolvers, err := NewBindNameResolvers(config.LDAPBindNameResolvers, config.LDAPBindNameRegex)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &IRODSAuth{
+		config:     config,
+		hasher:     hasher,
+		authCache:  authCache,
+		zones:      newFoldedIRODSZones(config),
+		resolvers:  resolvers,
+		normalizer: NewUsernameNormalizer(config),
+
+		policy:       NewUserPolicy(config),
+		reloadConfig: config,
+	}
+
+	if config.HasIRODSServiceAccount() {
+		pool, err := NewIRODSConnectionPool(config)
+		if err != nil {
+			return nil, err
+		}
+		auth.pool = pool
+	}
+
+	if len(config.LDAPUsernameEmailAVU) > 0 {
+		emailCacheTimeout := time.Duration(config.LDAPUsernameEmailCacheTimeout) * time.Second
+		auth.emailCache = gocache.New(emailCacheTimeout, emailCacheTimeout)
+	}
+
+	return auth, nil
 }
 
-// Auth authenticate a user via password
-func (auth *IRODSAuth) Auth(dn string, password string) (bool, error) {
-	authhash := makeHash(fmt.Sprintf("%s%s%s", hashSeed, dn, password))
+// Release releases resources
+func (auth *IRODSAuth) Release() {
+	if auth.pool != nil {
+		auth.pool.Release()
+	}
+}
+
+// canRevalidate checks if the service account can look up the cached user, users of other zones are not in the catalog it queries
+func (auth *IRODSAuth) canRevalidate(entry *AuthCacheEntry) bool {
+	if auth.pool == nil {
+		return false
+	}
+
+	// entries persisted by older versions have no zone
+	return len(entry.Zone) == 0 || entry.Zone == auth.config.IRODSZone
+}
+
+// Reconfigure applies reloadable settings of the config, the user policy, grace period and email cache timeouts
+func (auth *IRODSAuth) Reconfigure(config *commons.Config) {
+	auth.SetPolicy(NewUserPolicy(config))
+
+	auth.reloadMutex.Lock()
+	defer auth.reloadMutex.Unlock()
+
+	auth.reloadConfig = config
+}
+
+// getReloadConfig returns the config of reloadable settings in effect
+func (auth *IRODSAuth) getReloadConfig() *commons.Config {
+	auth.reloadMutex.RLock()
+	defer auth.reloadMutex.RUnlock()
+
+	return auth.reloadConfig
+}
+
+// SetPolicy replaces the user policy, binds in progress finish with the policy they started with.
+// Cached users of the naming context are evicted if the disabled flag changes, as AVUs are not cached
+func (auth *IRODSAuth) SetPolicy(policy *UserPolicy) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "IRODSAuth",
+		"function": "SetPolicy",
+	})
+
+	auth.reloadMutex.Lock()
+	defer auth.reloadMutex.Unlock()
+
+	if !auth.policy.HasSameMetaCheck(policy) {
+		evicted := auth.authCache.DeleteUnderBaseDN(auth.config.LDAPBaseDN)
+		logger.Infof("Disabled flag of the user policy changed, evicted %d cached users under %s", evicted, auth.config.LDAPBaseDN)
+	}
 
-	entry, _ := auth.authCache.Get(dn)
-	if cachedAuthHash, ok := entry.(string); ok {
+	auth.policy = policy
+}
+
+// SetTracer sets the tracer receiving results of authentication steps, for diagnostics.
+// It must be set before authenticating
+func (auth *IRODSAuth) SetTracer(tracer AuthTracer) {
+	auth.tracer = tracer
+}
+
+// trace reports the result of an authentication step to the tracer
+func (auth *IRODSAuth) trace(step string, result string, err error) {
+	if auth.tracer != nil {
+		auth.tracer(step, result, err)
+	}
+}
+
+// getPolicy returns the current user policy
+func (auth *IRODSAuth) getPolicy() *UserPolicy {
+	auth.reloadMutex.RLock()
+	defer auth.reloadMutex.RUnlock()
+
+	return auth.policy
+}
+
+// Auth authenticate a user via password
+func (auth *IRODSAuth) Auth(identity *BindIdentity, password string) (bool, error) {
+	dn := identity.DN
+	if entry, ok := auth.authCache.Get(dn); ok {
 		// has auth cache
-		if cachedAuthHash == authhash {
+		if auth.getPolicy().CheckEntry(entry) == nil && auth.hasher.Verify(entry.Verifier, dn, password) {
+			verifier := entry.Verifier
+			if len(verifier.MAC) == 0 {
+				// loaded from the store, checked with the MAC from now on
+				verifier = auth.hasher.AddMAC(verifier, dn, password)
+			}
+			auth.authCache.Use(dn, verifier)
+			auth.trace("auth cache", "hit", nil)
 			return true, nil
 		}
 	}
+	auth.trace("auth cache", "miss", nil)
+
+	// concurrent binds with the same credential share a single iRODS verification
+	_, err, _ := auth.authGroup.Do(auth.hasher.MakeKey(dn, password), func() (interface{}, error) {
+		return nil, auth.authIRODS(identity, password)
+	})
+	if err != nil {
+		if IsIRODSUnavailableError(err) {
+			auth.setIRODSAvailable(false)
+			if auth.authGrace(dn, password) {
+				auth.trace("grace mode", "accepted a recently verified credential", nil)
+				return true, nil
+			}
+			return false, err
+		}
 
-	if !ValidateDN(auth.config.LDAPBaseDN, dn) {
-		return false, fmt.Errorf("DN not matched")
+		auth.setIRODSAvailable(true)
+		return false, err
 	}
 
-	irodsUsername := GetUsernameFromDN(dn)
-	irodsAccount, err := irodsclient_types.CreateIRODSAccount(auth.config.IRODSHost, auth.config.IRODSPort, irodsUsername, auth.config.IRODSZone, irodsclient_types.AuthSchemeNative, password, "")
+	auth.setIRODSAvailable(true)
+	return true, nil
+}
+
+// authGrace accepts a credential that matched within the grace period, used only while iRODS is unavailable
+func (auth *IRODSAuth) authGrace(dn string, password string) bool {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "IRODSAuth",
+		"function": "authGrace",
+	})
+
+	entry, ok := auth.authCache.GetGrace(dn)
+	if !ok {
+		return false
+	}
+
+	err := auth.getPolicy().CheckEntry(entry)
 	if err != nil {
-		return false, err
+		auth.trace("grace mode", "", err)
+		return false
+	}
+
+	if !auth.hasher.Verify(entry.Verifier, dn, password) {
+		return false
+	}
+
+	logger.WithFields(log.Fields{
+		"audit":         true,
+		"grace_mode":    true,
+		"dn":            dn,
+		"last_verified": entry.Verified.Format(time.RFC3339),
+	}).Warn("Accepted bind in grace mode, iRODS is unavailable")
+	return true
+}
+
+// setIRODSAvailable records if iRODS is reachable, logging the transitions between strict and grace mode
+func (auth *IRODSAuth) setIRODSAvailable(available bool) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "IRODSAuth",
+		"function": "setIRODSAvailable",
+	})
+
+	auth.availabilityMutex.Lock()
+	defer auth.availabilityMutex.Unlock()
+
+	if auth.irodsUnavailable == !available {
+		return
+	}
+
+	auth.irodsUnavailable = !available
+	if available {
+		logger.Info("iRODS is available again, back to strict mode")
+	} else if auth.getReloadConfig().AuthGracePeriod > 0 {
+		logger.Warn("iRODS is unavailable, accepting recently verified credentials in grace mode")
+	} else {
+		logger.Warn("iRODS is unavailable")
+	}
+}
+
+// IsIRODSAvailable returns false if the last attempt to reach iRODS failed
+func (auth *IRODSAuth) IsIRODSAvailable() bool {
+	auth.availabilityMutex.Lock()
+	defer auth.availabilityMutex.Unlock()
+
+	return !auth.irodsUnavailable
+}
+
+// CheckIRODS checks if iRODS of the home zone accepts connections, within the timeout
+func (auth *IRODSAuth) CheckIRODS(timeout time.Duration) error {
+	return checkIRODSReachable(auth.config, timeout)
+}
+
+// ResolveBindName returns the iRODS username and zone of the bind name, mapped by aliases
+func (auth *IRODSAuth) ResolveBindName(name string) (string, *IRODSZone, error) {
+	return auth.resolveName(name, func(name string) (string, *IRODSZone, error) {
+		return ResolveBindName(name, auth.resolvers, auth.zones, auth.config.IRODSZone)
+	})
+}
+
+// NormalizeBindName normalizes the bind name and returns its identity
+func (auth *IRODSAuth) NormalizeBindName(name string) (*BindIdentity, error) {
+	name = auth.normalizer.Normalize(name)
+	if IsDN(name) && !IsDNUnderBaseDN(auth.config.LDAPBaseDN, name) {
+		return nil, fmt.Errorf("DN not matched")
+	}
+
+	name, err := auth.mapEmail(name)
+	if err != nil {
+		return nil, err
+	}
+
+	username, zone, err := auth.ResolveBindName(name)
+	if err != nil {
+		return nil, err
+	}
+	return auth.newBindIdentity(username, zone)
+}
+
+// NormalizeUsername normalizes the "user[#zone]" name, such as a searched uid, and returns its identity
+func (auth *IRODSAuth) NormalizeUsername(name string) (*BindIdentity, error) {
+	name, err := auth.mapEmail(auth.normalizer.Normalize(name))
+	if err != nil {
+		return nil, err
+	}
+
+	username, zone, err := auth.resolveName(name, auth.splitUsernameZone)
+	if err != nil {
+		return nil, err
+	}
+	return auth.newBindIdentity(username, zone)
+}
+
+// resolveName returns the username and zone of the name, mapped by aliases once.
+// An alias of the whole name is used instead of resolving the name, otherwise an alias of the username resolved.
+// Targets of aliases are not mapped again
+func (auth *IRODSAuth) resolveName(name string, resolve func(name string) (string, *IRODSZone, error)) (string, *IRODSZone, error) {
+	if target, ok := auth.normalizer.GetAlias(name); ok {
+		return auth.splitUsernameZone(target)
+	}
+
+	username, zone, err := resolve(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	username = auth.normalizer.Normalize(username)
+	target, ok := auth.normalizer.GetAlias(username)
+	if !ok {
+		return username, zone, nil
+	}
+
+	targetUsername, targetZoneName := SplitUsernameZone(target)
+	if len(targetZoneName) == 0 {
+		// the zone resolved is kept
+		return targetUsername, zone, nil
+	}
+	return auth.splitUsernameZone(target)
+}
+
+// splitUsernameZone returns the username and zone of the "user[#zone]" name, the home zone if not given
+func (auth *IRODSAuth) splitUsernameZone(name string) (string, *IRODSZone, error) {
+	username, zoneName := SplitUsernameZone(name)
+	if len(zoneName) == 0 {
+		zoneName = auth.config.IRODSZone
+	}
+
+	zone, ok := auth.zones[zoneName]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown zone %q", zoneName)
+	}
+	return username, zone, nil
+}
+
+// mapEmail maps an email to "user[#zone]", other names are returned as given
+func (auth *IRODSAuth) mapEmail(name string) (string, error) {
+	if auth.isEmail(name) {
+		return auth.lookupEmail(name)
+	}
+	return name, nil
+}
+
+// isEmail checks if the name is an email to look up, "user@zone" of a known zone is not
+func (auth *IRODSAuth) isEmail(name string) bool {
+	if auth.emailCache == nil || IsDN(name) {
+		return false
+	}
+
+	idx := strings.LastIndex(name, "@")
+	if idx <= 0 {
+		return false
+	}
+
+	_, isZone := auth.zones[name[idx+1:]]
+	return !isZone
+}
+
+// lookupEmail returns "user[#zone]" of the iRODS user having the email in the email AVU
+func (auth *IRODSAuth) lookupEmail(email string) (string, error) {
+	if cached, ok := auth.emailCache.Get(email); ok {
+		if err, ok := cached.(error); ok {
+			return "", err
+		}
+		return cached.(string), nil
+	}
+
+	var users []*irodsclient_types.IRODSUser
+	err := auth.pool.Run(func(conn *irodsclient_conn.IRODSConnection) error {
+		var err error
+		users, err = findIRODSUsersByMeta(conn, auth.config.LDAPUsernameEmailAVU, email)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	reloadConfig := auth.getReloadConfig()
+	if len(users) != 1 {
+		// cached briefly, so unknown emails do not query iRODS on every bind
+		err := fmt.Errorf("found %d users with email %q, exactly one is expected", len(users), email)
+		if reloadConfig.LDAPUsernameEmailNegativeCacheTimeout > 0 {
+			auth.emailCache.Set(email, err, time.Duration(reloadConfig.LDAPUsernameEmailNegativeCacheTimeout)*time.Second)
+		}
+		return "", err
+	}
+
+	name := users[0].Name
+	if users[0].Zone != auth.config.IRODSZone {
+		name = fmt.Sprintf("%s#%s", users[0].Name, users[0].Zone)
+	}
+
+	auth.emailCache.Set(email, name, time.Duration(reloadConfig.LDAPUsernameEmailCacheTimeout)*time.Second)
+	return name, nil
+}
+
+// newBindIdentity makes the identity of the user, the username is normalized
+func (auth *IRODSAuth) newBindIdentity(username string, zone *IRODSZone) (*BindIdentity, error) {
+	username = auth.normalizer.Normalize(username)
+	if len(username) == 0 {
+		return nil, fmt.Errorf("empty username")
+	}
+
+	uid := username
+	if zone.Name != auth.config.IRODSZone {
+		uid = fmt.Sprintf("%s#%s", username, zone.Name)
+	}
+
+	return &BindIdentity{
+		DN:       fmt.Sprintf("uid=%s,ou=People,%s", uid, auth.config.LDAPBaseDN),
+		Username: username,
+		Zone:     zone,
+	}, nil
+}
+
+// authIRODS verifies the credential against iRODS and caches it on success
+func (auth *IRODSAuth) authIRODS(identity *BindIdentity, password string) error {
+	irodsUsername := identity.Username
+	zone := identity.Zone
+
+	irodsAccount, err := irodsclient_types.CreateIRODSAccount(zone.Host, zone.Port, irodsUsername, zone.Name, irodsclient_types.AuthSchemeNative, password, "")
+	if err != nil {
+		return err
 	}
 
-	irodsConn := irodsclient_conn.NewIRODSConnection(irodsAccount, authRequestTimeout, applicationName)
-	err = irodsConn.Connect()
+	irodsConn, err := connectIRODS(auth.config, irodsAccount)
+	auth.trace("connect", fmt.Sprintf("%s#%s at %s:%d", irodsUsername, zone.Name, zone.Host, zone.Port), err)
 	if err != nil {
 		// auth fail
-		return false, err
+		return err
+	}
+	defer irodsConn.Disconnect()
+
+	user, groupNames, err := auth.lookupUser(irodsConn, irodsUsername, zone.Name)
+	if err != nil {
+		return err
 	}
 
-	// check groups
-	if len(auth.config.IRODSUserGroup) != 0 {
-		groupNames, err := irodsclient_fs.ListUserGroupNames(irodsConn, irodsUsername)
-		if err != nil {
-			return false, err
+	verifier, err := auth.hasher.MakeVerifier(identity.DN, password)
+	if err != nil {
+		return err
+	}
+
+	// replaces a stale entry made with an old password
+	auth.authCache.Set(identity.DN, user, groupNames, verifier)
+	auth.trace("auth cache", "stored", nil)
+	return nil
+}
+
+// lookupUser looks up the user and its groups and checks them against the user policy.
+// Queries run on the pool if available for the home zone and on the given connection otherwise
+func (auth *IRODSAuth) lookupUser(userConn *irodsclient_conn.IRODSConnection, username string, zone string) (*irodsclient_types.IRODSUser, []string, error) {
+	if auth.pool == nil || zone != auth.config.IRODSZone {
+		auth.trace("lookup", "on the user connection", nil)
+		return auth.lookupUserWithConn(userConn, username, zone)
+	}
+	auth.trace("lookup", "on the service account pool", nil)
+
+	var user *irodsclient_types.IRODSUser
+	var groupNames []string
+	var policyErr error
+	err := auth.pool.Run(func(conn *irodsclient_conn.IRODSConnection) error {
+		var err error
+		user, groupNames, err = auth.lookupUserWithConn(conn, username, zone)
+		if IsUserPolicyError(err) {
+			// the connection is still reusable
+			policyErr = err
+			return nil
 		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		belong := false
-		for _, groupName := range groupNames {
-			if auth.config.IRODSUserGroup == groupName {
-				belong = true
-				break
-			}
+	if policyErr != nil {
+		return nil, nil, policyErr
+	}
+	return user, groupNames, nil
+}
+
+// lookupUserWithConn looks up the user and its groups on the connection and checks them against the user policy
+func (auth *IRODSAuth) lookupUserWithConn(conn *irodsclient_conn.IRODSConnection, username string, zone string) (*irodsclient_types.IRODSUser, []string, error) {
+	policy := auth.getPolicy()
+
+	user, err := getIRODSUser(conn, username, zone)
+	if err != nil {
+		auth.trace("user", "", err)
+		return nil, nil, err
+	}
+	auth.trace("user", fmt.Sprintf("%s#%s, type %s", user.Name, user.Zone, user.Type), nil)
+
+	err = policy.CheckUser(string(user.Type), user.Zone)
+	auth.trace("user type and zone policy", "", err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groupNames, err := irodsclient_fs.ListUserGroupNames(conn, username)
+	if err != nil {
+		err = fmt.Errorf("failed to list groups of user %s - %v", username, err)
+		auth.trace("groups", "", err)
+		return nil, nil, err
+	}
+	auth.trace("groups", strings.Join(groupNames, ", "), nil)
+
+	err = policy.CheckGroups(groupNames)
+	auth.trace("group policy", "", err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if policy.NeedsMeta() {
+		metas, err := irodsclient_fs.ListUserMeta(conn, username)
+		if err != nil {
+			err = fmt.Errorf("failed to list metadata of user %s - %v", username, err)
+			auth.trace("metadata", "", err)
+			return nil, nil, err
 		}
 
-		if !belong {
-			return false, fmt.Errorf("user '%s' is not in a group '%s'", irodsUsername, auth.config.IRODSUserGroup)
+		err = policy.CheckMeta(metas)
+		auth.trace("metadata policy", "", err)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
 
-	defer irodsConn.Disconnect()
+	return user, groupNames, nil
+}
 
-	auth.authCache.Add(dn, authhash, 0)
-	return true, nil
+// ListCacheEntries returns unexpired auth cache entries of the naming context
+func (auth *IRODSAuth) ListCacheEntries() []AuthCacheEntry {
+	entries := []AuthCacheEntry{}
+	for _, entry := range auth.authCache.List() {
+		if IsDNUnderBaseDN(auth.config.LDAPBaseDN, entry.DN) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
 }
 
 // GetDNs returns DNs
 func (auth *IRODSAuth) GetDNs() []string {
 	users := []string{}
-	for k := range auth.authCache.Items() {
-		users = append(users, k)
+	for _, entry := range auth.ListCacheEntries() {
+		users = append(users, entry.DN)
 	}
 	return users
 }
-
-// makeHash returns hash string from plain text
-func makeHash(s string) string {
-	hash := sha256.New()
-	hash.Write([]byte(s))
-	hashBytes := hash.Sum(nil)
-	return hex.EncodeToString(hashBytes)
-}
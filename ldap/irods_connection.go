@@ -0,0 +1,305 @@
+package ldap
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	irodsclient_auth "github.com/cyverse/go-irodsclient/irods/auth"
+	irodsclient_conn "github.com/cyverse/go-irodsclient/irods/connection"
+	irodsclient_message "github.com/cyverse/go-irodsclient/irods/message"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/ldap-irods-auth/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+// IRODSUnavailableError is returned when iRODS cannot be reached, as opposed to rejecting the credential
+type IRODSUnavailableError struct {
+	Err error
+}
+
+// Error returns error message
+func (err *IRODSUnavailableError) Error() string {
+	return fmt.Sprintf("iRODS is unavailable - %v", err.Err)
+}
+
+// Unwrap returns the underlying error
+func (err *IRODSUnavailableError) Unwrap() error {
+	return err.Err
+}
+
+// IsIRODSUnavailableError checks if the error is caused by iRODS being unreachable
+func IsIRODSUnavailableError(err error) bool {
+	var unavailableErr *IRODSUnavailableError
+	return errors.As(err, &unavailableErr)
+}
+
+// connectIRODS makes a new authenticated connection to iRODS.
+// IRODSConnection.Connect dials without a timeout and with default TCP options,
+// so the socket is dialed here and the handshake of Connect is performed on it, bounded by the connect timeout.
+// The server version is not recorded in IRODSConnection this way, so XML is escaped for iRODS newer than 4.2.8.
+// Errors other than iRODS rejecting the login are returned as IRODSUnavailableError
+func connectIRODS(config *commons.Config, account *irodsclient_types.IRODSAccount) (*irodsclient_conn.IRODSConnection, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"function": "connectIRODS",
+	})
+
+	connectTimeout := time.Duration(config.IRODSConnectTimeout) * time.Second
+	dialer := net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: -1, // disabled
+	}
+
+	if config.IRODSTCPKeepAlive {
+		dialer.KeepAlive = time.Duration(config.IRODSTCPKeepAlivePeriod) * time.Second
+	}
+
+	server := net.JoinHostPort(account.Host, strconv.Itoa(account.Port))
+	logger.Debugf("Connecting to %s", server)
+
+	socket, err := dialer.Dial("tcp", server)
+	if err != nil {
+		return nil, &IRODSUnavailableError{
+			Err: fmt.Errorf("could not connect to specified host and port (%s) - %v", server, err),
+		}
+	}
+
+	handshakeSocket := newHandshakeConn(socket, time.Now().Add(connectTimeout))
+
+	operationTimeout := time.Duration(config.IRODSOperationTimeout) * time.Second
+	irodsConn := irodsclient_conn.NewIRODSConnection(account, operationTimeout, config.IRODSApplicationName)
+	irodsConn.RawBind(handshakeSocket)
+
+	err = handshakeIRODS(irodsConn, account, config.IRODSApplicationName, handshakeSocket)
+	if err != nil {
+		socket.Close()
+
+		if isIRODSAPIError(err) {
+			// rejected by iRODS
+			return nil, err
+		}
+
+		return nil, &IRODSUnavailableError{
+			Err: err,
+		}
+	}
+
+	handshakeSocket.EndHandshake()
+	return irodsConn, nil
+}
+
+// handshakeIRODS performs the startup, client-server negotiation and native login of IRODSConnection.Connect
+func handshakeIRODS(irodsConn *irodsclient_conn.IRODSConnection, account *irodsclient_types.IRODSAccount, applicationName string, socket net.Conn) error {
+	if account.AuthenticationScheme != irodsclient_types.AuthSchemeNative {
+		return fmt.Errorf("unsupported authentication scheme - %s", account.AuthenticationScheme)
+	}
+
+	if account.ClientServerNegotiation {
+		err := negotiateIRODS(irodsConn, account, applicationName, socket)
+		if err != nil {
+			return err
+		}
+	} else {
+		startup := irodsclient_message.NewIRODSMessageStartupPack(account, applicationName, false)
+		version := irodsclient_message.IRODSMessageVersion{}
+		err := irodsConn.Request(startup, &version, nil)
+		if err != nil {
+			return fmt.Errorf("could not receive a version message - %v", err)
+		}
+	}
+
+	authRequest := irodsclient_message.NewIRODSMessageAuthRequest()
+	authChallenge := irodsclient_message.IRODSMessageAuthChallenge{}
+	err := irodsConn.Request(authRequest, &authChallenge, nil)
+	if err != nil {
+		return fmt.Errorf("could not receive an authentication challenge message - %v", err)
+	}
+
+	encodedPassword, err := irodsclient_auth.GenerateAuthResponse(authChallenge.Challenge, account.Password)
+	if err != nil {
+		return fmt.Errorf("could not generate an authentication response - %v", err)
+	}
+
+	authResponse := irodsclient_message.NewIRODSMessageAuthResponse(encodedPassword, account.ProxyUser)
+	authResult := irodsclient_message.IRODSMessageAuthResult{}
+	return irodsConn.RequestAndCheck(authResponse, &authResult, nil)
+}
+
+// negotiateIRODS starts up the connection with client-server negotiation, switching to SSL if negotiated
+func negotiateIRODS(irodsConn *irodsclient_conn.IRODSConnection, account *irodsclient_types.IRODSAccount, applicationName string, socket net.Conn) error {
+	clientPolicy := irodsclient_types.CSNegotiationRequireTCP
+	if len(account.CSNegotiationPolicy) > 0 {
+		clientPolicy = account.CSNegotiationPolicy
+	}
+
+	startup := irodsclient_message.NewIRODSMessageStartupPack(account, applicationName, true)
+	err := irodsConn.RequestWithoutResponse(startup)
+	if err != nil {
+		return fmt.Errorf("could not send a startup - %v", err)
+	}
+
+	negotiationMessage, err := irodsConn.ReadMessage(nil)
+	if err != nil {
+		return fmt.Errorf("could not receive a negotiation message - %v", err)
+	}
+
+	if negotiationMessage.Body == nil {
+		return fmt.Errorf("could not receive a negotiation message body")
+	}
+
+	switch negotiationMessage.Body.Type {
+	case irodsclient_message.RODS_MESSAGE_VERSION_TYPE:
+		// the server does not negotiate
+		version := irodsclient_message.IRODSMessageVersion{}
+		return version.FromMessage(negotiationMessage)
+	case irodsclient_message.RODS_MESSAGE_CS_NEG_TYPE:
+	default:
+		return fmt.Errorf("unknown response message - %s", negotiationMessage.Body.Type)
+	}
+
+	negotiation := irodsclient_message.IRODSMessageCSNegotiation{}
+	err = negotiation.FromMessage(negotiationMessage)
+	if err != nil {
+		return fmt.Errorf("could not receive a negotiation message - %v", err)
+	}
+
+	serverPolicy, err := irodsclient_types.GetCSNegotiationRequire(negotiation.Result)
+	if err != nil {
+		return fmt.Errorf("unable to parse server policy - %v", err)
+	}
+
+	policyResult := irodsclient_types.PerformCSNegotiation(clientPolicy, serverPolicy)
+	if policyResult == irodsclient_types.CSNegotiationFailure {
+		return fmt.Errorf("client-server negotiation failed: %s, %s", string(clientPolicy), string(serverPolicy))
+	}
+
+	negotiationResult := irodsclient_message.NewIRODSMessageCSNegotiation(policyResult)
+	version := irodsclient_message.IRODSMessageVersion{}
+	err = irodsConn.Request(negotiationResult, &version, nil)
+	if err != nil {
+		return fmt.Errorf("could not receive a version message - %v", err)
+	}
+
+	if policyResult == irodsclient_types.CSNegotiationUseSSL {
+		err = startIRODSSSL(irodsConn, account, socket)
+		if err != nil {
+			return fmt.Errorf("could not start up SSL - %v", err)
+		}
+	}
+	return nil
+}
+
+// startIRODSSSL switches the connection to SSL and sends the encryption settings
+func startIRODSSSL(irodsConn *irodsclient_conn.IRODSConnection, account *irodsclient_types.IRODSAccount, socket net.Conn) error {
+	sslConfig := account.SSLConfiguration
+	if sslConfig == nil {
+		return fmt.Errorf("SSL Configuration is not set")
+	}
+
+	caCertPool := x509.NewCertPool()
+	caCert, err := sslConfig.ReadCACert()
+	if err == nil {
+		caCertPool.AppendCertsFromPEM(caCert)
+	}
+
+	sslSocket := tls.Client(socket, &tls.Config{
+		RootCAs:    caCertPool,
+		ServerName: account.Host,
+	})
+
+	err = sslSocket.Handshake()
+	if err != nil {
+		return fmt.Errorf("SSL Handshake error - %v", err)
+	}
+
+	irodsConn.RawBind(sslSocket)
+
+	encryptionKey := make([]byte, sslConfig.EncryptionKeySize)
+	_, err = rand.Read(encryptionKey)
+	if err != nil {
+		return fmt.Errorf("could not generate a shared secret - %v", err)
+	}
+
+	sslSetting := irodsclient_message.NewIRODSMessageSSLSettings(sslConfig.EncryptionAlgorithm, sslConfig.EncryptionKeySize, sslConfig.SaltSize, sslConfig.HashRounds)
+	err = irodsConn.RequestWithoutResponse(sslSetting)
+	if err != nil {
+		return fmt.Errorf("could not send a ssl setting message - %v", err)
+	}
+
+	sslSharedSecret := irodsclient_message.NewIRODSMessageSSLSharedSecret(encryptionKey)
+	err = irodsConn.RequestWithoutResponse(sslSharedSecret)
+	if err != nil {
+		return fmt.Errorf("could not send a ssl shared secret message - %v", err)
+	}
+	return nil
+}
+
+// handshakeConn is a socket whose deadlines are capped until the handshake ends,
+// IRODSConnection sets a deadline of the operation timeout for every read and write
+type handshakeConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+// newHandshakeConn creates a handshakeConn whose deadlines are capped at the deadline given
+func newHandshakeConn(socket net.Conn, deadline time.Time) *handshakeConn {
+	socket.SetDeadline(deadline)
+	return &handshakeConn{
+		Conn:     socket,
+		deadline: deadline,
+	}
+}
+
+// EndHandshake stops capping deadlines, the connection must not be in use
+func (conn *handshakeConn) EndHandshake() {
+	conn.deadline = time.Time{}
+	conn.Conn.SetDeadline(time.Time{})
+}
+
+// SetDeadline sets read and write deadlines
+func (conn *handshakeConn) SetDeadline(t time.Time) error {
+	return conn.Conn.SetDeadline(conn.capDeadline(t))
+}
+
+// SetReadDeadline sets read deadline
+func (conn *handshakeConn) SetReadDeadline(t time.Time) error {
+	return conn.Conn.SetReadDeadline(conn.capDeadline(t))
+}
+
+// SetWriteDeadline sets write deadline
+func (conn *handshakeConn) SetWriteDeadline(t time.Time) error {
+	return conn.Conn.SetWriteDeadline(conn.capDeadline(t))
+}
+
+func (conn *handshakeConn) capDeadline(t time.Time) time.Time {
+	if conn.deadline.IsZero() || (!t.IsZero() && t.Before(conn.deadline)) {
+		return t
+	}
+	return conn.deadline
+}
+
+// isIRODSAPIError checks if the error is returned by iRODS for a request, as opposed to a network failure
+func isIRODSAPIError(err error) bool {
+	var irodsErr *irodsclient_types.IRODSError
+	return errors.As(err, &irodsErr)
+}
+
+// checkIRODSReachable checks if iRODS of the home zone accepts connections
+func checkIRODSReachable(config *commons.Config, timeout time.Duration) error {
+	server := net.JoinHostPort(config.IRODSHost, strconv.Itoa(config.IRODSPort))
+	socket, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return &IRODSUnavailableError{
+			Err: fmt.Errorf("could not connect to specified host and port (%s) - %v", server, err),
+		}
+	}
+
+	socket.Close()
+	return nil
+}
@@ -0,0 +1,88 @@
+package ldap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+func TestConnectIRODSTimeout(t *testing.T) {
+	// accepts connections but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen - %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// kept open until the test ends
+			defer conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	account, err := irodsclient_types.CreateIRODSAccount("127.0.0.1", addr.Port, "alice", "iplant", irodsclient_types.AuthSchemeNative, "secret", "")
+	if err != nil {
+		t.Fatalf("failed to create an account - %v", err)
+	}
+
+	config := commons.NewDefaultConfig()
+	config.IRODSConnectTimeout = 1
+	config.IRODSOperationTimeout = 30
+
+	start := time.Now()
+	_, err = connectIRODS(config, account)
+	if !IsIRODSUnavailableError(err) {
+		t.Fatalf("expected iRODS to be unavailable, got %v", err)
+	}
+
+	// the handshake is bounded by the connect timeout, not the operation timeout
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected to give up in the connect timeout, took %s", elapsed.String())
+	}
+
+	// nothing listens on the port anymore
+	listener.Close()
+	_, err = connectIRODS(config, account)
+	if !IsIRODSUnavailableError(err) {
+		t.Errorf("expected iRODS to be unavailable, got %v", err)
+	}
+}
+
+func TestHandshakeConnDeadline(t *testing.T) {
+	deadline := time.Now().Add(time.Minute)
+	conn := &handshakeConn{deadline: deadline}
+
+	tests := []struct {
+		name     string
+		t        time.Time
+		expected time.Time
+	}{
+		{"earlier", deadline.Add(-time.Second), deadline.Add(-time.Second)},
+		{"later", deadline.Add(time.Second), deadline},
+		{"none", time.Time{}, deadline},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := conn.capDeadline(test.t); !actual.Equal(test.expected) {
+				t.Errorf("expected %s, got %s", test.expected.String(), actual.String())
+			}
+		})
+	}
+
+	// not capped after the handshake
+	conn.deadline = time.Time{}
+	later := deadline.Add(time.Hour)
+	if actual := conn.capDeadline(later); !actual.Equal(later) {
+		t.Errorf("expected %s, got %s", later.String(), actual.String())
+	}
+}
@@ -0,0 +1,232 @@
+package ldap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	irodsclient_conn "github.com/cyverse/go-irodsclient/irods/connection"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/ldap-irods-auth/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+// pooledIRODSConnection is a connection of IRODSConnectionPool
+type pooledIRODSConnection struct {
+	conn        *irodsclient_conn.IRODSConnection
+	lastUsed    time.Time
+	lastChecked time.Time // last successful login or health check
+}
+
+// IRODSConnectionPool is a bounded pool of service account connections to iRODS for catalog queries.
+// Idle connections are closed after the idle timeout and checked before reuse
+type IRODSConnectionPool struct {
+	config              *commons.Config
+	account             *irodsclient_types.IRODSAccount
+	idleTimeout         time.Duration
+	healthCheckInterval time.Duration
+	waitTimeout         time.Duration
+
+	slots  chan bool // holds a token per connection in use
+	idle   []*pooledIRODSConnection
+	closed bool
+	mutex  sync.Mutex
+
+	stopChan chan bool
+	stopOnce sync.Once
+}
+
+// NewIRODSConnectionPool creates a new IRODSConnectionPool for the service account
+func NewIRODSConnectionPool(config *commons.Config) (*IRODSConnectionPool, error) {
+	if !config.HasIRODSServiceAccount() {
+		return nil, fmt.Errorf("iRODS service account is not configured")
+	}
+
+	account, err := irodsclient_types.CreateIRODSAccount(config.IRODSHost, config.IRODSPort, config.IRODSAdminUsername, config.IRODSZone, irodsclient_types.AuthSchemeNative, config.IRODSAdminPassword, "")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &IRODSConnectionPool{
+		config:              config,
+		account:             account,
+		idleTimeout:         time.Duration(config.IRODSPoolIdleTimeout) * time.Second,
+		healthCheckInterval: time.Duration(config.IRODSPoolHealthCheckInterval) * time.Second,
+		waitTimeout:         time.Duration(config.IRODSOperationTimeout) * time.Second,
+
+		slots: make(chan bool, config.IRODSPoolMaxConnections),
+		idle:  []*pooledIRODSConnection{},
+
+		stopChan: make(chan bool),
+	}
+
+	go pool.runJanitor()
+
+	return pool, nil
+}
+
+// Release closes idle connections, connections in use are closed when returned
+func (pool *IRODSConnectionPool) Release() {
+	pool.stopOnce.Do(func() {
+		close(pool.stopChan)
+
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+
+		pool.closed = true
+		for _, pooledConn := range pool.idle {
+			pooledConn.conn.Disconnect()
+		}
+		pool.idle = nil
+	})
+}
+
+// Run runs the function with a pooled connection.
+// The connection is discarded if the function fails with an error other than a missing object
+func (pool *IRODSConnectionPool) Run(fn func(conn *irodsclient_conn.IRODSConnection) error) error {
+	pooledConn, err := pool.get()
+	if err != nil {
+		return err
+	}
+
+	err = fn(pooledConn.conn)
+	pool.put(pooledConn, err == nil || irodsclient_types.IsFileNotFoundError(err))
+	return err
+}
+
+// get returns an idle connection or makes a new one, waiting for a free slot if the pool is full
+func (pool *IRODSConnectionPool) get() (*pooledIRODSConnection, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "IRODSConnectionPool",
+		"function": "get",
+	})
+
+	timer := time.NewTimer(pool.waitTimeout)
+	defer timer.Stop()
+
+	select {
+	case pool.slots <- true:
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out waiting for a free iRODS connection, %d connections are in use", cap(pool.slots))
+	}
+
+	for {
+		pooledConn, err := pool.popIdle()
+		if err != nil {
+			<-pool.slots
+			return nil, err
+		}
+
+		if pooledConn == nil {
+			break
+		}
+
+		if pool.checkHealth(pooledConn) {
+			return pooledConn, nil
+		}
+
+		logger.Debug("discarding an unhealthy iRODS connection")
+		pooledConn.conn.Disconnect()
+	}
+
+	conn, err := connectIRODS(pool.config, pool.account)
+	if err != nil {
+		<-pool.slots
+		return nil, err
+	}
+
+	return &pooledIRODSConnection{
+		conn:        conn,
+		lastChecked: time.Now(),
+	}, nil
+}
+
+// popIdle returns the most recently used idle connection, or nil if there is none
+func (pool *IRODSConnectionPool) popIdle() (*pooledIRODSConnection, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.closed {
+		return nil, fmt.Errorf("iRODS connection pool is closed")
+	}
+
+	for len(pool.idle) > 0 {
+		last := len(pool.idle) - 1
+		pooledConn := pool.idle[last]
+		pool.idle = pool.idle[:last]
+
+		if time.Since(pooledConn.lastUsed) < pool.idleTimeout {
+			return pooledConn, nil
+		}
+		pooledConn.conn.Disconnect()
+	}
+	return nil, nil
+}
+
+// put returns the connection to the pool, or closes it if it is not reusable
+func (pool *IRODSConnectionPool) put(pooledConn *pooledIRODSConnection, reusable bool) {
+	defer func() {
+		<-pool.slots
+	}()
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.closed || !reusable || !pooledConn.conn.IsConnected() {
+		pooledConn.conn.Disconnect()
+		return
+	}
+
+	pooledConn.lastUsed = time.Now()
+	pool.idle = append(pool.idle, pooledConn)
+}
+
+// checkHealth runs a cheap query on the connection if it has not been checked recently
+func (pool *IRODSConnectionPool) checkHealth(pooledConn *pooledIRODSConnection) bool {
+	if !pooledConn.conn.IsConnected() {
+		return false
+	}
+
+	if pool.healthCheckInterval <= 0 || time.Since(pooledConn.lastChecked) < pool.healthCheckInterval {
+		return true
+	}
+
+	_, err := getIRODSUser(pooledConn.conn, pool.account.ClientUser, pool.account.ClientZone)
+	if err != nil {
+		return false
+	}
+
+	pooledConn.lastChecked = time.Now()
+	return true
+}
+
+// closeIdle closes connections idle longer than the idle timeout
+func (pool *IRODSConnectionPool) closeIdle() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	idle := []*pooledIRODSConnection{}
+	for _, pooledConn := range pool.idle {
+		if time.Since(pooledConn.lastUsed) >= pool.idleTimeout {
+			pooledConn.conn.Disconnect()
+			continue
+		}
+		idle = append(idle, pooledConn)
+	}
+	pool.idle = idle
+}
+
+func (pool *IRODSConnectionPool) runJanitor() {
+	ticker := time.NewTicker(pool.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.closeIdle()
+		case <-pool.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_conn "github.com/cyverse/go-irodsclient/irods/connection"
+	irodsclient_message "github.com/cyverse/go-irodsclient/irods/message"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+)
+
+// validateQueryValue rejects values that cannot be safely placed in a general query condition
+func validateQueryValue(value string) error {
+	if len(value) == 0 {
+		return fmt.Errorf("empty value")
+	}
+
+	if strings.ContainsAny(value, "'\\") {
+		return fmt.Errorf("invalid character in value %q", value)
+	}
+	return nil
+}
+
+// getIRODSUser returns the iRODS user, go-irodsclient only has a lookup for groups.
+// Returns FileNotFoundError if the user does not exist
+func getIRODSUser(conn *irodsclient_conn.IRODSConnection, username string, zone string) (*irodsclient_types.IRODSUser, error) {
+	if conn == nil || !conn.IsConnected() {
+		return nil, fmt.Errorf("connection is nil or disconnected")
+	}
+
+	err := validateQueryValue(username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateQueryValue(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	query := irodsclient_message.NewIRODSMessageQuery(irodsclient_common.MaxQueryRows, 0, 0, 0)
+	query.AddSelect(irodsclient_common.ICAT_COLUMN_USER_ID, 1)
+	query.AddSelect(irodsclient_common.ICAT_COLUMN_USER_NAME, 1)
+	query.AddSelect(irodsclient_common.ICAT_COLUMN_USER_TYPE, 1)
+	query.AddSelect(irodsclient_common.ICAT_COLUMN_USER_ZONE, 1)
+
+	query.AddCondition(irodsclient_common.ICAT_COLUMN_USER_NAME, fmt.Sprintf("= '%s'", username))
+	query.AddCondition(irodsclient_common.ICAT_COLUMN_USER_ZONE, fmt.Sprintf("= '%s'", zone))
+
+	queryResult := irodsclient_message.IRODSMessageQueryResult{}
+	err = conn.Request(query, &queryResult, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not receive a user query result message - %v", err)
+	}
+
+	err = queryResult.CheckError()
+	if err != nil {
+		if irodsclient_types.GetIRODSErrorCode(err) == irodsclient_common.CAT_NO_ROWS_FOUND {
+			return nil, irodsclient_types.NewFileNotFoundErrorf("could not find a user %s#%s", username, zone)
+		}
+		return nil, fmt.Errorf("received a user query error - %v", err)
+	}
+
+	if queryResult.RowCount == 0 {
+		return nil, irodsclient_types.NewFileNotFoundErrorf("could not find a user %s#%s", username, zone)
+	}
+
+	user := &irodsclient_types.IRODSUser{
+		ID: -1,
+	}
+
+	for _, sqlResult := range queryResult.SQLResult {
+		if len(sqlResult.Values) == 0 {
+			continue
+		}
+
+		value := sqlResult.Values[0]
+		switch sqlResult.AttributeIndex {
+		case int(irodsclient_common.ICAT_COLUMN_USER_ID):
+			userID, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse user id - %s", value)
+			}
+			user.ID = userID
+		case int(irodsclient_common.ICAT_COLUMN_USER_NAME):
+			user.Name = value
+		case int(irodsclient_common.ICAT_COLUMN_USER_TYPE):
+			user.Type = irodsclient_types.IRODSUserType(value)
+		case int(irodsclient_common.ICAT_COLUMN_USER_ZONE):
+			user.Zone = value
+		}
+	}
+
+	return user, nil
+}
+
+// findIRODSUsersByMeta returns iRODS users having the AVU
+func findIRODSUsersByMeta(conn *irodsclient_conn.IRODSConnection, name string, value string) ([]*irodsclient_types.IRODSUser, error) {
+	if conn == nil || !conn.IsConnected() {
+		return nil, fmt.Errorf("connection is nil or disconnected")
+	}
+
+	err := validateQueryValue(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateQueryValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	query := irodsclient_message.NewIRODSMessageQuery(irodsclient_common.MaxQueryRows, 0, 0, 0)
+	query.AddSelect(irodsclient_common.ICAT_COLUMN_USER_NAME, 1)
+	query.AddSelect(irodsclient_common.ICAT_COLUMN_USER_ZONE, 1)
+
+	query.AddCondition(irodsclient_common.ICAT_COLUMN_META_USER_ATTR_NAME, fmt.Sprintf("= '%s'", name))
+	query.AddCondition(irodsclient_common.ICAT_COLUMN_META_USER_ATTR_VALUE, fmt.Sprintf("= '%s'", value))
+
+	queryResult := irodsclient_message.IRODSMessageQueryResult{}
+	err = conn.Request(query, &queryResult, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not receive a user metadata query result message - %v", err)
+	}
+
+	err = queryResult.CheckError()
+	if err != nil {
+		if irodsclient_types.GetIRODSErrorCode(err) == irodsclient_common.CAT_NO_ROWS_FOUND {
+			return []*irodsclient_types.IRODSUser{}, nil
+		}
+		return nil, fmt.Errorf("received a user metadata query error - %v", err)
+	}
+
+	users := make([]*irodsclient_types.IRODSUser, queryResult.RowCount)
+	for i := range users {
+		users[i] = &irodsclient_types.IRODSUser{
+			ID: -1,
+		}
+	}
+
+	for _, sqlResult := range queryResult.SQLResult {
+		if len(sqlResult.Values) != queryResult.RowCount {
+			return nil, fmt.Errorf("received a user metadata query result with %d values, %d rows are expected", len(sqlResult.Values), queryResult.RowCount)
+		}
+
+		for row, value := range sqlResult.Values {
+			switch sqlResult.AttributeIndex {
+			case int(irodsclient_common.ICAT_COLUMN_USER_NAME):
+				users[row].Name = value
+			case int(irodsclient_common.ICAT_COLUMN_USER_ZONE):
+				users[row].Zone = value
+			}
+		}
+	}
+
+	return users, nil
+}
@@ -0,0 +1,69 @@
+package ldap
+
+import (
+	"strings"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// IRODSZone is an iRODS zone users can authenticate against
+type IRODSZone struct {
+	Name string
+	Host string
+	Port int
+}
+
+// NewIRODSZones creates a zone table with the home zone and federated zones
+func NewIRODSZones(config *commons.Config) map[string]*IRODSZone {
+	zones := map[string]*IRODSZone{
+		config.IRODSZone: {
+			Name: config.IRODSZone,
+			Host: config.IRODSHost,
+			Port: config.IRODSPort,
+		},
+	}
+
+	for _, zoneConfig := range config.IRODSZones {
+		port := zoneConfig.Port
+		if port == 0 {
+			port = commons.IRODSPortDefault
+		}
+
+		zones[zoneConfig.Zone] = &IRODSZone{
+			Name: zoneConfig.Zone,
+			Host: zoneConfig.Host,
+			Port: port,
+		}
+	}
+	return zones
+}
+
+// newFoldedIRODSZones creates a zone table, also keyed by lower case zone names if usernames are case folded,
+// as zones in bind names are folded with usernames
+func newFoldedIRODSZones(config *commons.Config) map[string]*IRODSZone {
+	zones := NewIRODSZones(config)
+	if !config.LDAPUsernameCaseFold {
+		return zones
+	}
+
+	folded := map[string]*IRODSZone{}
+	for name, zone := range zones {
+		folded[strings.ToLower(name)] = zone
+	}
+
+	for name, zone := range folded {
+		if _, ok := zones[name]; !ok {
+			zones[name] = zone
+		}
+	}
+	return zones
+}
+
+// SplitUsernameZone splits "user#zone" into user and zone, zone is empty if not given
+func SplitUsernameZone(name string) (string, string) {
+	idx := strings.LastIndex(name, "#")
+	if idx < 0 {
+		return name, ""
+	}
+	return name[:idx], name[idx+1:]
+}
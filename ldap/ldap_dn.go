@@ -25,6 +25,19 @@ func ParseDN(dn string) map[string]string {
 	return fieldMap
 }
 
+// GetDNValues returns values of all RDNs with the key in dn, in order
+func GetDNValues(dn string, key string) []string {
+	values := []string{}
+	fields := strings.Split(dn, ",")
+	for _, field := range fields {
+		kv := strings.Split(field, "=")
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == key {
+			values = append(values, strings.TrimSpace(kv[1]))
+		}
+	}
+	return values
+}
+
 func ParseDNFilter(filter string) (string, []string) {
 	if filter[0] == '(' {
 		filter = filter[1 : len(filter)-1]
@@ -92,3 +105,26 @@ func ValidateDN(baseDN string, dn string) bool {
 	}
 	return true
 }
+
+// IsDNUnderBaseDN checks if dn is baseDN or below it, comparing RDNs from the end case-insensitively
+func IsDNUnderBaseDN(baseDN string, dn string) bool {
+	baseFields := strings.Split(baseDN, ",")
+	fields := strings.Split(dn, ",")
+	if len(fields) < len(baseFields) {
+		return false
+	}
+
+	offset := len(fields) - len(baseFields)
+	for i, baseField := range baseFields {
+		baseKV := strings.SplitN(baseField, "=", 2)
+		kv := strings.SplitN(fields[offset+i], "=", 2)
+		if len(baseKV) != 2 || len(kv) != 2 {
+			return false
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(baseKV[0]), strings.TrimSpace(kv[0])) || !strings.EqualFold(strings.TrimSpace(baseKV[1]), strings.TrimSpace(kv[1])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package ldap
+
+import (
+	"net"
+	_ "unsafe" // for go:linkname
+
+	"github.com/vjeantet/ldapserver"
+)
+
+// serveLDAPServer is the accept loop of ldapserver.Server on its Listener.
+// ldapserver only exports ListenAndServe, which binds a TCP address itself.
+// The signature must be checked when upgrading ldapserver
+//
+//go:linkname serveLDAPServer github.com/vjeantet/ldapserver.(*Server).serve
+func serveLDAPServer(server *ldapserver.Server) error
+
+// serveLDAP serves on the listener given until the listener is closed or the server is stopped
+func serveLDAP(server *ldapserver.Server, listener net.Listener) error {
+	server.Listener = listener
+	return serveLDAPServer(server)
+}
@@ -0,0 +1 @@
+// allows the body-less declaration of serveLDAPServer, see ldapserver.go
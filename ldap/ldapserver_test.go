@@ -0,0 +1,69 @@
+package ldap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vjeantet/ldapserver"
+)
+
+// acceptCountingListener is a net.Listener that counts Accept calls, no connection is accepted
+type acceptCountingListener struct {
+	net.Listener
+	accepts chan bool
+}
+
+// Accept records the call and returns a timeout, as a closed limitListener does
+func (listener *acceptCountingListener) Accept() (net.Conn, error) {
+	select {
+	case listener.accepts <- true:
+	default:
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	return nil, &net.OpError{
+		Op:   "accept",
+		Net:  listener.Addr().Network(),
+		Addr: listener.Addr(),
+		Err:  &listenerClosedError{},
+	}
+}
+
+func TestServeLDAP(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen - %v", err)
+	}
+	defer inner.Close()
+
+	listener := &acceptCountingListener{
+		Listener: inner,
+		accepts:  make(chan bool, 1),
+	}
+
+	server := ldapserver.NewServer()
+	server.Handle(ldapserver.NewRouteMux())
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- serveLDAP(server, listener)
+	}()
+
+	select {
+	case <-listener.accepts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listener given is not served")
+	}
+
+	server.Stop()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serving did not stop")
+	}
+}
@@ -0,0 +1,337 @@
+package ldap
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ldap_message "github.com/lor00x/goldap/message"
+	log "github.com/sirupsen/logrus"
+	"github.com/vjeantet/ldapserver"
+)
+
+const (
+	rejectWriteTimeout time.Duration = 5 * time.Second
+
+	// backoff of Accept retries after errors, e.g. running out of file descriptors
+	acceptRetryDelayMin time.Duration = 5 * time.Millisecond
+	acceptRetryDelayMax time.Duration = 1 * time.Second
+)
+
+// listenerClosedError is returned by Accept of a closed limitListener.
+// ldapserver panics on other Accept errors and checks if it is stopping only between Accepts,
+// so it is a timeout that makes the accept loop retry and see the server is stopping
+type listenerClosedError struct{}
+
+func (err *listenerClosedError) Error() string {
+	return "listener closed"
+}
+
+// Timeout returns true
+func (err *listenerClosedError) Timeout() bool {
+	return true
+}
+
+// Temporary returns true
+func (err *listenerClosedError) Temporary() bool {
+	return true
+}
+
+// limitListener is a net.Listener that limits concurrent connections globally and per source IP.
+// Connections over the limits receive a busy Notice of Disconnection and are closed
+type limitListener struct {
+	net.Listener
+
+	accepted uint64 // connections accepted, including rejected ones
+	closed   int32  // set by Close
+
+	maxConnections      int
+	maxConnectionsPerIP int
+	onClose             func(conn net.Conn)
+
+	connections      int
+	connectionsPerIP map[string]int
+	conns            map[*limitConn]bool // open connections
+	mutex            sync.Mutex
+
+	probesDialing int
+	probes        map[string]chan bool // local addresses of liveness probes, closed when accepted
+	probeCond     *sync.Cond           // signaled when a probe is dialed
+}
+
+// limitConn is a net.Conn accepted by limitListener
+type limitConn struct {
+	net.Conn
+
+	listener  *limitListener
+	ip        string
+	closeOnce sync.Once
+}
+
+// newLimitListener wraps the listener, onClose is called when an accepted connection is closed
+func newLimitListener(listener net.Listener, maxConnections int, maxConnectionsPerIP int, onClose func(conn net.Conn)) *limitListener {
+	limitListener := &limitListener{
+		Listener:            listener,
+		maxConnections:      maxConnections,
+		maxConnectionsPerIP: maxConnectionsPerIP,
+		onClose:             onClose,
+		connectionsPerIP:    map[string]int{},
+		conns:               map[*limitConn]bool{},
+		probes:              map[string]chan bool{},
+	}
+	limitListener.probeCond = sync.NewCond(&limitListener.mutex)
+	return limitListener
+}
+
+// Accept accepts a connection within the limits.
+// Errors are retried with backoff, an error is returned only once the listener is closed
+func (listener *limitListener) Accept() (net.Conn, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "limitListener",
+		"function": "Accept",
+	})
+
+	retryDelay := time.Duration(0)
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&listener.closed) != 0 {
+				return nil, &net.OpError{
+					Op:   "accept",
+					Net:  listener.Addr().Network(),
+					Addr: listener.Addr(),
+					Err:  &listenerClosedError{},
+				}
+			}
+
+			retryDelay *= 2
+			if retryDelay < acceptRetryDelayMin {
+				retryDelay = acceptRetryDelayMin
+			} else if retryDelay > acceptRetryDelayMax {
+				retryDelay = acceptRetryDelayMax
+			}
+
+			logger.WithError(err).Warnf("failed to accept a connection, retrying in %s", retryDelay.String())
+			time.Sleep(retryDelay)
+			continue
+		}
+		retryDelay = 0
+
+		if listener.acceptProbe(conn) {
+			// not served nor counted against the limits
+			conn.Close()
+			continue
+		}
+
+		atomic.AddUint64(&listener.accepted, 1)
+
+		limitConn := &limitConn{
+			Conn:     conn,
+			listener: listener,
+			ip:       getAddrIP(conn.RemoteAddr()),
+		}
+
+		if listener.acquire(limitConn) {
+			return limitConn, nil
+		}
+
+		logger.Warnf("rejecting connection from %s, too many connections", conn.RemoteAddr().String())
+		go rejectBusy(conn)
+	}
+}
+
+// Probe checks if connections are accepted, within the timeout.
+// The probe connection is closed once accepted, so it does not take a slot of the limits
+func (listener *limitListener) Probe(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	listener.mutex.Lock()
+	listener.probesDialing++
+	listener.mutex.Unlock()
+
+	conn, err := net.DialTimeout("tcp", getDialAddr(listener.Addr()), timeout)
+
+	listener.mutex.Lock()
+	listener.probesDialing--
+
+	var probeAddr string
+	acceptedChan := make(chan bool)
+	if err == nil {
+		probeAddr = conn.LocalAddr().String()
+		listener.probes[probeAddr] = acceptedChan
+	}
+
+	listener.probeCond.Broadcast()
+	listener.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service - %v", err)
+	}
+	defer conn.Close()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-acceptedChan:
+		return nil
+	case <-timer.C:
+	}
+
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+
+	if _, ok := listener.probes[probeAddr]; !ok {
+		// accepted in the meantime
+		return nil
+	}
+
+	// queued in the backlog without the server accepting it
+	delete(listener.probes, probeAddr)
+	return fmt.Errorf("service does not accept connections")
+}
+
+// acceptProbe checks if the connection is a liveness probe, and reports the probe accepted.
+// While a probe is being dialed, connections from the host itself wait until its address is known
+func (listener *limitListener) acceptProbe(conn net.Conn) bool {
+	remoteIP := getAddrIP(conn.RemoteAddr())
+	if remoteIP != getAddrIP(conn.LocalAddr()) {
+		return false
+	}
+
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+
+	remoteAddr := conn.RemoteAddr().String()
+	for {
+		if acceptedChan, ok := listener.probes[remoteAddr]; ok {
+			delete(listener.probes, remoteAddr)
+			close(acceptedChan)
+			return true
+		}
+
+		if listener.probesDialing == 0 {
+			return false
+		}
+		listener.probeCond.Wait()
+	}
+}
+
+// Close stops accepting connections, connections accepted are not closed
+func (listener *limitListener) Close() error {
+	atomic.StoreInt32(&listener.closed, 1)
+	return listener.Listener.Close()
+}
+
+// Accepted returns the number of connections accepted
+func (listener *limitListener) Accepted() uint64 {
+	return atomic.LoadUint64(&listener.accepted)
+}
+
+// CloseConnections closes connections accepted and still open, returns the number of connections closed
+func (listener *limitListener) CloseConnections() int {
+	listener.mutex.Lock()
+	conns := []*limitConn{}
+	for conn := range listener.conns {
+		conns = append(conns, conn)
+	}
+	listener.mutex.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+func (listener *limitListener) acquire(conn *limitConn) bool {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+
+	if listener.maxConnections > 0 && listener.connections >= listener.maxConnections {
+		return false
+	}
+
+	if listener.maxConnectionsPerIP > 0 && listener.connectionsPerIP[conn.ip] >= listener.maxConnectionsPerIP {
+		return false
+	}
+
+	listener.connections++
+	listener.connectionsPerIP[conn.ip]++
+	listener.conns[conn] = true
+	return true
+}
+
+func (listener *limitListener) release(conn *limitConn) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+
+	listener.connections--
+	listener.connectionsPerIP[conn.ip]--
+	if listener.connectionsPerIP[conn.ip] <= 0 {
+		delete(listener.connectionsPerIP, conn.ip)
+	}
+	delete(listener.conns, conn)
+}
+
+// Close closes the connection and releases its slot
+func (conn *limitConn) Close() error {
+	err := conn.Conn.Close()
+
+	conn.closeOnce.Do(func() {
+		conn.listener.release(conn)
+		if conn.listener.onClose != nil {
+			conn.listener.onClose(conn.Conn)
+		}
+	})
+	return err
+}
+
+// getAddrIP returns IP address part of the network address
+func getAddrIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// rejectBusy sends a busy Notice of Disconnection and closes the connection
+func rejectBusy(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+
+	res := ldapserver.NewExtendedResponse(ldapserver.LDAPResultBusy)
+	res.SetDiagnosticMessage("too many connections")
+	res.SetResponseName(ldapserver.NoticeOfDisconnection)
+
+	msg := ldap_message.NewLDAPMessageWithProtocolOp(res)
+	data, err := msg.Write()
+	if err != nil {
+		return
+	}
+
+	conn.Write(data.Bytes())
+}
+
+// getDialAddr returns the address to connect to the listener locally
+func getDialAddr(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return addr.String()
+	}
+
+	loopback := net.IPv4(127, 0, 0, 1)
+	if tcpAddr.IP.To4() == nil {
+		loopback = net.IPv6loopback
+	}
+	return net.JoinHostPort(loopback.String(), strconv.Itoa(tcpAddr.Port))
+}
@@ -0,0 +1,153 @@
+package ldap
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen - %v", err)
+	}
+
+	listener := newLimitListener(inner, 0, 1, nil)
+	defer listener.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect - %v", err)
+		}
+		return conn
+	}
+
+	client1 := dial()
+	defer client1.Close()
+
+	conn1, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept - %v", err)
+	}
+
+	// over the per-IP limit, rejected in Accept and closed
+	client2 := dial()
+	defer client2.Close()
+
+	acceptedChan := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			acceptedChan <- conn
+		}
+	}()
+
+	client2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for {
+		_, err := client2.Read(buf)
+		if err != nil {
+			break
+		}
+	}
+
+	if listener.Accepted() != 2 {
+		t.Errorf("expected 2 connections accepted, got %d", listener.Accepted())
+	}
+
+	// a slot is released when the connection is closed
+	conn1.Close()
+	client3 := dial()
+	defer client3.Close()
+
+	select {
+	case conn3 := <-acceptedChan:
+		conn3.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection within the limit is not accepted")
+	}
+
+	if closed := listener.CloseConnections(); closed != 0 {
+		t.Errorf("expected no open connections, closed %d", closed)
+	}
+}
+
+func TestLimitListenerClosed(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen - %v", err)
+	}
+
+	listener := newLimitListener(inner, 0, 0, nil)
+	listener.Close()
+
+	_, err = listener.Accept()
+	opErr, ok := err.(*net.OpError)
+	if !ok || !opErr.Timeout() {
+		t.Errorf("closed listener must return a timeout, got %v", err)
+	}
+}
+
+func TestLimitListenerProbe(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen - %v", err)
+	}
+
+	listener := newLimitListener(inner, 1, 1, nil)
+	defer listener.Close()
+
+	acceptedChan := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(acceptedChan)
+				return
+			}
+			acceptedChan <- conn
+		}
+	}()
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect - %v", err)
+	}
+	defer client.Close()
+
+	conn := <-acceptedChan
+	defer conn.Close()
+
+	// at the limits, probes are accepted without taking a slot
+	for i := 0; i < 3; i++ {
+		if err := listener.Probe(5 * time.Second); err != nil {
+			t.Errorf("probe %d failed - %v", i, err)
+		}
+	}
+
+	select {
+	case conn := <-acceptedChan:
+		t.Errorf("probe must not be handed to the server, got one from %s", conn.RemoteAddr().String())
+	default:
+	}
+
+	if accepted := listener.Accepted(); accepted != 1 {
+		t.Errorf("expected 1 connection accepted, got %d", accepted)
+	}
+}
+
+func TestLimitListenerProbeNotAccepted(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen - %v", err)
+	}
+
+	listener := newLimitListener(inner, 0, 0, nil)
+	defer listener.Close()
+
+	// nothing accepts, the probe stays in the backlog
+	if err := listener.Probe(100 * time.Millisecond); err == nil {
+		t.Error("probe must fail while connections are not accepted")
+	}
+}
@@ -0,0 +1,209 @@
+package ldap
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	ldap_message "github.com/lor00x/goldap/message"
+	log "github.com/sirupsen/logrus"
+	"github.com/vjeantet/ldapserver"
+)
+
+// NamingContext is an LDAP suffix served by its own iRODS backend
+type NamingContext struct {
+	baseDN         string
+	irodsAuth      *IRODSAuth
+	attributes     map[string]string
+	attributeNames []string // sorted
+	attributeMutex sync.RWMutex
+}
+
+// NewNamingContext creates a new NamingContext with a config returned by Config.GetNamingContexts
+func NewNamingContext(config *commons.Config, hasher *CredentialHasher, authCache *AuthCache) (*NamingContext, error) {
+	irodsAuth, err := NewIRODSAuth(config, hasher, authCache)
+	if err != nil {
+		return nil, err
+	}
+
+	namingContext := &NamingContext{
+		baseDN:    config.LDAPBaseDN,
+		irodsAuth: irodsAuth,
+	}
+	namingContext.setAttributes(config.LDAPAttributes)
+	return namingContext, nil
+}
+
+// Reload applies the user policy, attributes, grace period and email cache timeouts of the config,
+// other settings require a restart
+func (namingContext *NamingContext) Reload(config *commons.Config) {
+	namingContext.irodsAuth.Reconfigure(config)
+	namingContext.setAttributes(config.LDAPAttributes)
+}
+
+// setAttributes replaces attributes of user entries, defaults are used if none is given
+func (namingContext *NamingContext) setAttributes(attributes map[string]string) {
+	if len(attributes) == 0 {
+		attributes = commons.NewDefaultLDAPAttributes()
+	}
+
+	attributeNames := []string{}
+	for name := range attributes {
+		attributeNames = append(attributeNames, name)
+	}
+	sort.Strings(attributeNames)
+
+	namingContext.attributeMutex.Lock()
+	defer namingContext.attributeMutex.Unlock()
+
+	namingContext.attributes = attributes
+	namingContext.attributeNames = attributeNames
+}
+
+// Release releases resources
+func (namingContext *NamingContext) Release() {
+	namingContext.irodsAuth.Release()
+}
+
+// GetBaseDN returns the base DN
+func (namingContext *NamingContext) GetBaseDN() string {
+	return namingContext.baseDN
+}
+
+// GetIRODSAuth returns IRODSAuth
+func (namingContext *NamingContext) GetIRODSAuth() *IRODSAuth {
+	return namingContext.irodsAuth
+}
+
+// Contains checks if the dn is in the naming context.
+// Bind names that are not DNs are in the naming context if they resolve to a zone of it
+func (namingContext *NamingContext) Contains(dn string) bool {
+	if !IsDN(dn) {
+		_, _, err := namingContext.irodsAuth.ResolveBindName(dn)
+		return err == nil
+	}
+	return IsDNUnderBaseDN(namingContext.baseDN, dn)
+}
+
+// UserEntry is a user entry served in search results
+type UserEntry struct {
+	DN         string
+	Attributes []UserEntryAttribute // in attribute name order
+}
+
+// UserEntryAttribute is an attribute of UserEntry
+type UserEntryAttribute struct {
+	Name  string
+	Value string
+}
+
+// SearchResultEntry makes a search result entry of the user entry
+func (entry *UserEntry) SearchResultEntry() ldap_message.SearchResultEntry {
+	e := ldapserver.NewSearchResultEntry(entry.DN)
+	for _, attribute := range entry.Attributes {
+		e.AddAttribute(ldap_message.AttributeDescription(attribute.Name), ldap_message.AttributeValue(attribute.Value))
+	}
+	return e
+}
+
+// NewUserEntry makes a user entry with requested attributes, all if none is requested
+func (namingContext *NamingContext) NewUserEntry(dn string, uid string, username string, zone string, requested map[string]string) *UserEntry {
+	replacer := strings.NewReplacer("{uid}", uid, "{username}", username, "{zone}", zone)
+
+	namingContext.attributeMutex.RLock()
+	defer namingContext.attributeMutex.RUnlock()
+
+	entry := &UserEntry{
+		DN:         dn,
+		Attributes: []UserEntryAttribute{},
+	}
+	for _, name := range namingContext.attributeNames {
+		if len(requested) > 0 {
+			if _, ok := requested[name]; !ok {
+				continue
+			}
+		}
+
+		entry.Attributes = append(entry.Attributes, UserEntryAttribute{
+			Name:  name,
+			Value: replacer.Replace(namingContext.attributes[name]),
+		})
+	}
+	return entry
+}
+
+// Search returns user entries matching the filter, made from the auth cache entries given and the uid asked in the filter
+func (namingContext *NamingContext) Search(filter string, requested map[string]string, cacheEntries []AuthCacheEntry) []*UserEntry {
+	userEntries := []*UserEntry{}
+
+	// included all id
+	usersAdded := map[string]string{}
+
+	for _, entry := range cacheEntries {
+		if CheckDNFilter(filter, entry.DN) {
+			log.Printf("Returning search result - %s", entry.DN)
+
+			uid := GetUsernameFromDN(entry.DN)
+			zone := entry.Zone
+			if len(zone) == 0 {
+				zone = namingContext.irodsAuth.config.IRODSZone
+			}
+
+			userEntries = append(userEntries, namingContext.NewUserEntry(entry.DN, uid, entry.Username, zone, requested))
+
+			usersAdded[uid] = entry.DN
+		}
+	}
+
+	// include asked id
+	askedUser := ExtractFilterValue(filter, "uid")
+	log.Printf("asked user := %s", askedUser)
+	if len(askedUser) > 0 {
+		identity, err := namingContext.irodsAuth.NormalizeUsername(askedUser)
+		if err != nil {
+			log.Printf("Not adding an asked user %s to search result - %v", askedUser, err)
+		} else {
+			uid := GetUsernameFromDN(identity.DN)
+			if _, ok := usersAdded[uid]; !ok {
+				// not added
+				log.Printf("Adding an asked user %s to search result", uid)
+				userEntries = append(userEntries, namingContext.NewUserEntry(identity.DN, uid, identity.Username, identity.Zone.Name, requested))
+
+				usersAdded[uid] = identity.DN
+			}
+		}
+	}
+
+	return userEntries
+}
+
+// findNamingContext returns the most specific naming context containing the dn.
+// Bind names that are not DNs belong to the first naming context resolving them, or to the first one
+func findNamingContext(namingContexts []*NamingContext, dn string) *NamingContext {
+	if !IsDN(dn) {
+		for _, namingContext := range namingContexts {
+			if namingContext.Contains(dn) {
+				return namingContext
+			}
+		}
+
+		if len(namingContexts) > 0 {
+			return namingContexts[0]
+		}
+		return nil
+	}
+
+	var found *NamingContext
+	foundLen := -1
+	for _, namingContext := range namingContexts {
+		if namingContext.Contains(dn) {
+			baseLen := len(strings.Split(namingContext.baseDN, ","))
+			if baseLen > foundLen {
+				found = namingContext
+				foundLen = baseLen
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,53 @@
+package ldap
+
+import (
+	"strings"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	"golang.org/x/text/unicode/norm"
+)
+
+// UsernameNormalizer normalizes bind names and usernames, so one identity maps to one iRODS account
+type UsernameNormalizer struct {
+	trim     bool
+	nfc      bool
+	caseFold bool
+	aliases  map[string]string // normalized alias to "user" or "user#zone"
+}
+
+// NewUsernameNormalizer creates a new UsernameNormalizer
+func NewUsernameNormalizer(config *commons.Config) *UsernameNormalizer {
+	normalizer := &UsernameNormalizer{
+		trim:     config.LDAPUsernameTrim,
+		nfc:      config.LDAPUsernameNFC,
+		caseFold: config.LDAPUsernameCaseFold,
+		aliases:  map[string]string{},
+	}
+
+	for alias, target := range config.LDAPUsernameAliases {
+		normalizer.aliases[normalizer.Normalize(alias)] = normalizer.Normalize(target)
+	}
+	return normalizer
+}
+
+// Normalize applies trimming, Unicode NFC and case folding as configured
+func (normalizer *UsernameNormalizer) Normalize(name string) string {
+	if normalizer.trim {
+		name = strings.TrimSpace(name)
+	}
+
+	if normalizer.nfc {
+		name = norm.NFC.String(name)
+	}
+
+	if normalizer.caseFold {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// GetAlias returns the target of the normalized alias
+func (normalizer *UsernameNormalizer) GetAlias(name string) (string, bool) {
+	target, ok := normalizer.aliases[name]
+	return target, ok
+}
@@ -0,0 +1,191 @@
+package ldap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// newTestIRODSAuth creates an IRODSAuth without the service account, bind names are normalized without iRODS
+func newTestIRODSAuth(t *testing.T, config *commons.Config) *IRODSAuth {
+	config.IRODSHost = "data.example.org"
+	config.IRODSZone = "iplant"
+	config.IRODSZones = commons.IRODSZoneTable{
+		{Zone: "tempZone", Host: "fed.example.org"},
+	}
+	config.LDAPBaseDN = "dc=example,dc=org"
+	config.AuthCacheCleanupInterval = 0
+
+	authCache, err := NewAuthCache(config)
+	if err != nil {
+		t.Fatalf("failed to create an auth cache - %v", err)
+	}
+
+	auth, err := NewIRODSAuth(config, newTestHasher(t), authCache)
+	if err != nil {
+		t.Fatalf("failed to create an iRODS auth - %v", err)
+	}
+	return auth
+}
+
+func TestUsernameNormalizer(t *testing.T) {
+	tests := []struct {
+		name     string
+		trim     bool
+		nfc      bool
+		caseFold bool
+		input    string
+		expected string
+	}{
+		{"none", false, false, false, " Alice ", " Alice "},
+		{"trim", true, false, false, " Alice\t", "Alice"},
+		{"case fold", false, false, true, "Alice", "alice"},
+		{"NFC", false, true, false, "José", "José"},
+		{"NFD kept", false, false, false, "José", "José"},
+		{"all", true, true, true, " JOSÉ ", "josé"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := commons.NewDefaultConfig()
+			config.LDAPUsernameTrim = test.trim
+			config.LDAPUsernameNFC = test.nfc
+			config.LDAPUsernameCaseFold = test.caseFold
+
+			if actual := NewUsernameNormalizer(config).Normalize(test.input); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIRODSAuthNormalizeBindName(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.LDAPBindNameResolvers = []string{"uid", "upn", "bare"}
+	config.LDAPUsernameCaseFold = true
+	config.LDAPUsernameAliases = map[string]string{
+		"Alice.Smith": "asmith",
+		"bob":         "robert#tempZone",
+		// chained aliases are not followed
+		"carol": "dave",
+		"dave":  "erin",
+	}
+
+	auth := newTestIRODSAuth(t, config)
+	defer auth.Release()
+
+	tests := []struct {
+		bindName string
+		dn       string
+		valid    bool
+	}{
+		{"uid=alice,ou=People,dc=example,dc=org", "uid=alice,ou=People,dc=example,dc=org", true},
+		{" UID=Alice,ou=People,dc=example,dc=org ", "uid=alice,ou=People,dc=example,dc=org", true},
+		{"alice", "uid=alice,ou=People,dc=example,dc=org", true},
+		{"alice@tempZone", "uid=alice#tempZone,ou=People,dc=example,dc=org", true},
+		{"alice#iplant", "uid=alice,ou=People,dc=example,dc=org", true},
+		// aliases apply to whole names and resolved usernames
+		{"alice.smith", "uid=asmith,ou=People,dc=example,dc=org", true},
+		{"uid=Alice.Smith,ou=People,dc=example,dc=org", "uid=asmith,ou=People,dc=example,dc=org", true},
+		{"bob", "uid=robert#tempZone,ou=People,dc=example,dc=org", true},
+		{"carol", "uid=dave,ou=People,dc=example,dc=org", true},
+		{"uid=carol,ou=People,dc=example,dc=org", "uid=dave,ou=People,dc=example,dc=org", true},
+		{"carol@tempZone", "uid=dave#tempZone,ou=People,dc=example,dc=org", true},
+		{"dave", "uid=erin,ou=People,dc=example,dc=org", true},
+		{"uid=alice,ou=People,dc=other,dc=org", "", false},
+		{"alice@otherZone", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.bindName, func(t *testing.T) {
+			identity, err := auth.NormalizeBindName(test.bindName)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+
+			if err == nil && identity.DN != test.dn {
+				t.Errorf("expected %s, got %s", test.dn, identity.DN)
+			}
+		})
+	}
+}
+
+func TestIRODSAuthNormalizeUsername(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.LDAPUsernameAliases = map[string]string{
+		"carol": "dave",
+		"dave":  "erin#tempZone",
+	}
+
+	auth := newTestIRODSAuth(t, config)
+	defer auth.Release()
+
+	tests := []struct {
+		name  string
+		dn    string
+		valid bool
+	}{
+		{"alice", "uid=alice,ou=People,dc=example,dc=org", true},
+		{"alice#tempZone", "uid=alice#tempZone,ou=People,dc=example,dc=org", true},
+		// mapped once
+		{"carol", "uid=dave,ou=People,dc=example,dc=org", true},
+		{"carol#tempZone", "uid=dave#tempZone,ou=People,dc=example,dc=org", true},
+		{"dave", "uid=erin#tempZone,ou=People,dc=example,dc=org", true},
+		{"alice#otherZone", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := auth.NormalizeUsername(test.name)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+
+			if err == nil && identity.DN != test.dn {
+				t.Errorf("expected %s, got %s", test.dn, identity.DN)
+			}
+		})
+	}
+}
+
+func TestIRODSAuthEmailCache(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.LDAPBindNameResolvers = []string{"uid", "upn", "bare"}
+	config.LDAPUsernameEmailAVU = "email"
+
+	auth := newTestIRODSAuth(t, config)
+	defer auth.Release()
+
+	// looked up before, iRODS is not queried
+	auth.emailCache.Set("alice@example.org", "alice", 0)
+	auth.emailCache.Set("bob@example.org", "bob#tempZone", 0)
+	auth.emailCache.Set("nobody@example.org", fmt.Errorf("found 0 users with email %q, exactly one is expected", "nobody@example.org"), time.Minute)
+
+	tests := []struct {
+		bindName string
+		dn       string
+		valid    bool
+	}{
+		{"alice@example.org", "uid=alice,ou=People,dc=example,dc=org", true},
+		{"bob@example.org", "uid=bob#tempZone,ou=People,dc=example,dc=org", true},
+		// a known zone is not an email domain
+		{"carol@tempZone", "uid=carol#tempZone,ou=People,dc=example,dc=org", true},
+		{"nobody@example.org", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.bindName, func(t *testing.T) {
+			identity, err := auth.NormalizeBindName(test.bindName)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+
+			if err == nil && identity.DN != test.dn {
+				t.Errorf("expected %s, got %s", test.dn, identity.DN)
+			}
+		})
+	}
+}
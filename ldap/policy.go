@@ -0,0 +1,124 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// UserPolicyError is returned when an iRODS user is not allowed to bind
+type UserPolicyError struct {
+	Reason string
+}
+
+// Error returns error message
+func (err *UserPolicyError) Error() string {
+	return fmt.Sprintf("user is not allowed - %s", err.Reason)
+}
+
+// IsUserPolicyError checks if the error is caused by the user policy
+func IsUserPolicyError(err error) bool {
+	var policyErr *UserPolicyError
+	return errors.As(err, &policyErr)
+}
+
+// UserPolicy decides which iRODS users are allowed to bind, by user type, zone, group and AVU flag
+type UserPolicy struct {
+	allowedTypes     []string
+	deniedTypes      []string
+	allowedZones     []string
+	deniedZones      []string
+	requiredGroup    string
+	disabledAVUName  string
+	disabledAVUValue string
+}
+
+// NewUserPolicy creates a new UserPolicy
+func NewUserPolicy(config *commons.Config) *UserPolicy {
+	return &UserPolicy{
+		allowedTypes:     config.AuthAllowedUserTypes,
+		deniedTypes:      config.AuthDeniedUserTypes,
+		allowedZones:     config.AuthAllowedZones,
+		deniedZones:      config.AuthDeniedZones,
+		requiredGroup:    config.IRODSUserGroup,
+		disabledAVUName:  config.AuthDisabledAVUName,
+		disabledAVUValue: config.AuthDisabledAVUValue,
+	}
+}
+
+// NeedsMeta checks if the policy needs AVUs of the user
+func (policy *UserPolicy) NeedsMeta() bool {
+	return len(policy.disabledAVUName) > 0
+}
+
+// CheckUser checks user type and zone
+func (policy *UserPolicy) CheckUser(userType string, zone string) error {
+	if len(policy.allowedTypes) > 0 && !containsString(policy.allowedTypes, userType) {
+		return &UserPolicyError{Reason: fmt.Sprintf("user type %q is not allowed", userType)}
+	}
+
+	if containsString(policy.deniedTypes, userType) {
+		return &UserPolicyError{Reason: fmt.Sprintf("user type %q is denied", userType)}
+	}
+
+	if len(policy.allowedZones) > 0 && !containsString(policy.allowedZones, zone) {
+		return &UserPolicyError{Reason: fmt.Sprintf("zone %q is not allowed", zone)}
+	}
+
+	if containsString(policy.deniedZones, zone) {
+		return &UserPolicyError{Reason: fmt.Sprintf("zone %q is denied", zone)}
+	}
+	return nil
+}
+
+// CheckGroups checks membership of the required group
+func (policy *UserPolicy) CheckGroups(groupNames []string) error {
+	if len(policy.requiredGroup) > 0 && !containsString(groupNames, policy.requiredGroup) {
+		return &UserPolicyError{Reason: fmt.Sprintf("user is not in a group %q", policy.requiredGroup)}
+	}
+	return nil
+}
+
+// CheckMeta checks that the user is not flagged as disabled
+func (policy *UserPolicy) CheckMeta(metas []*irodsclient_types.IRODSMeta) error {
+	if !policy.NeedsMeta() {
+		return nil
+	}
+
+	for _, meta := range metas {
+		if meta.Name == policy.disabledAVUName && meta.Value == policy.disabledAVUValue {
+			return &UserPolicyError{Reason: fmt.Sprintf("user is flagged with %s=%s", meta.Name, meta.Value)}
+		}
+	}
+	return nil
+}
+
+// CheckEntry checks user type, zone and groups of the auth cache entry.
+// The disabled flag is checked when the entry is verified against iRODS, as AVUs are not cached
+func (policy *UserPolicy) CheckEntry(entry *AuthCacheEntry) error {
+	err := policy.CheckUser(entry.UserType, entry.Zone)
+	if err != nil {
+		return err
+	}
+	return policy.CheckGroups(entry.Groups)
+}
+
+// HasSameMetaCheck checks if the policies flag the same users as disabled
+func (policy *UserPolicy) HasSameMetaCheck(other *UserPolicy) bool {
+	if !policy.NeedsMeta() && !other.NeedsMeta() {
+		return true
+	}
+	return policy.disabledAVUName == other.disabledAVUName && policy.disabledAVUValue == other.disabledAVUValue
+}
+
+// containsString checks if the string is in the list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
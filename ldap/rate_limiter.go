@@ -0,0 +1,90 @@
+package ldap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+	gocache "github.com/patrickmn/go-cache"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// idle limiters are dropped after this period, they are full again anyway
+	rateLimiterIdleTimeout time.Duration = 10 * time.Minute
+)
+
+// RateLimiter limits LDAP operations per source IP and per bind DN using token buckets
+type RateLimiter struct {
+	config   *commons.Config
+	limiters *gocache.Cache
+	mutex    sync.Mutex
+}
+
+// NewRateLimiter creates a new RateLimiter
+func NewRateLimiter(config *commons.Config) *RateLimiter {
+	return &RateLimiter{
+		config:   config,
+		limiters: gocache.New(rateLimiterIdleTimeout, rateLimiterIdleTimeout),
+	}
+}
+
+// Reconfigure applies the rates and bursts of the config, buckets start full again
+func (limiter *RateLimiter) Reconfigure(config *commons.Config) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.config = config
+	limiter.limiters.Flush()
+}
+
+// Allow checks if an operation from the client IP, bound or binding as the DN, is allowed.
+// An empty client IP or DN is not limited, so they can be checked one after another
+func (limiter *RateLimiter) Allow(clientIP string, dn string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+
+	// reserve tokens from all applicable buckets, or none of them
+	reservations := []*rate.Reservation{}
+	if limiter.config.RateLimitIPRate > 0 && len(clientIP) > 0 {
+		ipLimiter := limiter.getLimiter("ip:"+clientIP, limiter.config.RateLimitIPRate, limiter.config.RateLimitIPBurst)
+		reservations = append(reservations, ipLimiter.ReserveN(now, 1))
+	}
+
+	if limiter.config.RateLimitDNRate > 0 && len(dn) > 0 {
+		dnLimiter := limiter.getLimiter("dn:"+dn, limiter.config.RateLimitDNRate, limiter.config.RateLimitDNBurst)
+		reservations = append(reservations, dnLimiter.ReserveN(now, 1))
+	}
+
+	allowed := true
+	for _, reservation := range reservations {
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			allowed = false
+			break
+		}
+	}
+
+	if !allowed {
+		for _, reservation := range reservations {
+			reservation.CancelAt(now)
+		}
+	}
+
+	return allowed
+}
+
+func (limiter *RateLimiter) getLimiter(key string, limit float64, burst int) *rate.Limiter {
+	if item, ok := limiter.limiters.Get(key); ok {
+		if rateLimiter, ok := item.(*rate.Limiter); ok {
+			// refresh expiration
+			limiter.limiters.Set(key, rateLimiter, 0)
+			return rateLimiter
+		}
+	}
+
+	rateLimiter := rate.NewLimiter(rate.Limit(limit), burst)
+	limiter.limiters.Set(key, rateLimiter, 0)
+	return rateLimiter
+}
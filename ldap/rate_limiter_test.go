@@ -0,0 +1,108 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// newTestRateLimiter creates a RateLimiter whose buckets do not refill during a test
+func newTestRateLimiter(ipBurst int, dnBurst int) *RateLimiter {
+	config := commons.NewDefaultConfig()
+	config.RateLimitIPRate = 0
+	config.RateLimitDNRate = 0
+	if ipBurst > 0 {
+		config.RateLimitIPRate = 0.001
+		config.RateLimitIPBurst = ipBurst
+	}
+	if dnBurst > 0 {
+		config.RateLimitDNRate = 0.001
+		config.RateLimitDNBurst = dnBurst
+	}
+	return NewRateLimiter(config)
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	type operation struct {
+		clientIP string
+		dn       string
+		allowed  bool
+	}
+
+	alice := "uid=alice,ou=People,dc=example,dc=org"
+	bob := "uid=bob,ou=People,dc=example,dc=org"
+
+	tests := []struct {
+		name       string
+		ipBurst    int
+		dnBurst    int
+		operations []operation
+	}{
+		{"IP burst", 2, 0, []operation{
+			{"10.0.0.1", alice, true},
+			{"10.0.0.1", bob, true},
+			{"10.0.0.1", alice, false},
+			{"10.0.0.2", alice, true},
+		}},
+		{"DN burst", 0, 2, []operation{
+			{"10.0.0.1", alice, true},
+			{"10.0.0.2", alice, true},
+			{"10.0.0.3", alice, false},
+			{"10.0.0.3", bob, true},
+		}},
+		{"anonymous is limited by IP only", 1, 1, []operation{
+			{"10.0.0.1", "", true},
+			{"10.0.0.2", "", true},
+			{"10.0.0.1", "", false},
+		}},
+		// a denied operation does not consume tokens of the other bucket
+		{"all or none", 2, 1, []operation{
+			{"10.0.0.1", alice, true},
+			{"10.0.0.1", alice, false},
+			{"10.0.0.1", bob, true},
+			{"10.0.0.1", bob, false},
+		}},
+		// binds check the IP before the bind name is normalized, then the DN
+		{"IP and DN checked separately", 1, 1, []operation{
+			{"10.0.0.1", "", true},
+			{"", alice, true},
+			{"10.0.0.1", "", false},
+			{"", alice, false},
+			{"10.0.0.2", "", true},
+		}},
+		{"disabled", 0, 0, []operation{
+			{"10.0.0.1", alice, true},
+			{"10.0.0.1", alice, true},
+			{"10.0.0.1", alice, true},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := newTestRateLimiter(test.ipBurst, test.dnBurst)
+			for i, op := range test.operations {
+				if actual := limiter.Allow(op.clientIP, op.dn); actual != op.allowed {
+					t.Errorf("operation %d from %s as %q - expected %v, got %v", i, op.clientIP, op.dn, op.allowed, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterReconfigure(t *testing.T) {
+	limiter := newTestRateLimiter(1, 0)
+	if !limiter.Allow("10.0.0.1", "") || limiter.Allow("10.0.0.1", "") {
+		t.Fatal("expected a burst of 1")
+	}
+
+	config := commons.NewDefaultConfig()
+	config.RateLimitIPRate = 0.001
+	config.RateLimitIPBurst = 2
+	config.RateLimitDNRate = 0
+	limiter.Reconfigure(config)
+
+	// buckets start full with the new burst
+	if !limiter.Allow("10.0.0.1", "") || !limiter.Allow("10.0.0.1", "") || limiter.Allow("10.0.0.1", "") {
+		t.Error("expected a burst of 2 after reconfiguring")
+	}
+}
@@ -0,0 +1,187 @@
+package ldap
+
+import (
+	"sync"
+	"time"
+
+	irodsclient_conn "github.com/cyverse/go-irodsclient/irods/connection"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Revalidator periodically checks that cached users still exist in iRODS and still satisfy
+// the policy of their naming context, evicting entries that no longer qualify.
+// Entries of naming contexts without the service account and users of zones other than the
+// home zone of the naming context cannot be checked, they are kept until the cache expires them
+type Revalidator struct {
+	namingContexts []*NamingContext
+	authCache      *AuthCache
+	interval       time.Duration
+
+	stopChan chan bool
+	stopOnce sync.Once
+}
+
+// revalidationResult counts cached users of a revalidation pass
+type revalidationResult struct {
+	checked   int
+	evicted   int
+	failed    int
+	unchecked int
+}
+
+// NewRevalidator creates a new Revalidator of the naming contexts sharing the auth cache
+func NewRevalidator(namingContexts []*NamingContext, authCache *AuthCache, interval time.Duration) *Revalidator {
+	return &Revalidator{
+		namingContexts: namingContexts,
+		authCache:      authCache,
+		interval:       interval,
+		stopChan:       make(chan bool),
+	}
+}
+
+// Start starts revalidation in background
+func (revalidator *Revalidator) Start() {
+	go func() {
+		ticker := time.NewTicker(revalidator.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				revalidator.Revalidate()
+			case <-revalidator.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops revalidation
+func (revalidator *Revalidator) Stop() {
+	revalidator.stopOnce.Do(func() {
+		close(revalidator.stopChan)
+	})
+}
+
+// Revalidate checks all cached users once, each with the naming context serving its DN
+func (revalidator *Revalidator) Revalidate() {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "Revalidator",
+		"function": "Revalidate",
+	})
+
+	entries, unchecked := groupRevalidationEntries(revalidator.namingContexts, revalidator.authCache.ListAll())
+
+	result := revalidationResult{
+		unchecked: unchecked,
+	}
+	for _, namingContext := range revalidator.namingContexts {
+		contextEntries := entries[namingContext]
+		if len(contextEntries) == 0 {
+			continue
+		}
+
+		revalidator.revalidateNamingContext(namingContext, contextEntries, &result)
+	}
+
+	if result.checked == 0 && result.failed == 0 {
+		return
+	}
+
+	logger.Infof("Revalidated %d cached users, evicted %d, failed to check %d, %d cannot be checked", result.checked, result.evicted, result.failed, result.unchecked)
+}
+
+// groupRevalidationEntries groups cache entries by the most specific naming context serving them,
+// returns the groups and the number of entries that cannot be checked
+func groupRevalidationEntries(namingContexts []*NamingContext, cacheEntries []AuthCacheEntry) (map[*NamingContext][]AuthCacheEntry, int) {
+	entries := map[*NamingContext][]AuthCacheEntry{}
+	unchecked := 0
+
+	for _, entry := range cacheEntries {
+		namingContext := findNamingContext(namingContexts, entry.DN)
+		if namingContext == nil || !namingContext.GetIRODSAuth().canRevalidate(&entry) {
+			unchecked++
+			continue
+		}
+
+		entries[namingContext] = append(entries[namingContext], entry)
+	}
+	return entries, unchecked
+}
+
+// revalidateNamingContext checks cached users of the naming context, failures of an entry are logged and the entry is kept.
+// The pass of the naming context stops if iRODS is unreachable
+func (revalidator *Revalidator) revalidateNamingContext(namingContext *NamingContext, entries []AuthCacheEntry, result *revalidationResult) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "Revalidator",
+		"function": "revalidateNamingContext",
+	})
+
+	irodsAuth := namingContext.GetIRODSAuth()
+	for i, entry := range entries {
+		user, groupNames, qualified, err := revalidator.revalidateEntry(irodsAuth, &entry)
+		if err != nil {
+			if IsIRODSUnavailableError(err) {
+				// keep remaining entries, it may be a temporary problem
+				result.failed += len(entries) - i
+				logger.WithError(err).Errorf("aborting revalidation of naming context %q", namingContext.GetBaseDN())
+				return
+			}
+
+			result.failed++
+			logger.WithError(err).Warnf("failed to revalidate %s, keeping it", entry.DN)
+			continue
+		}
+
+		result.checked++
+		if !qualified {
+			if revalidator.authCache.DeleteEntry(entry.DN, entry.Verifier) {
+				result.evicted++
+			}
+			continue
+		}
+
+		revalidator.authCache.Update(entry.DN, entry.Verifier, user, groupNames)
+	}
+}
+
+// revalidateEntry returns current user info and groups of the user, and if the user still qualifies
+func (revalidator *Revalidator) revalidateEntry(irodsAuth *IRODSAuth, entry *AuthCacheEntry) (*irodsclient_types.IRODSUser, []string, bool, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "Revalidator",
+		"function": "revalidateEntry",
+	})
+
+	var user *irodsclient_types.IRODSUser
+	var groupNames []string
+	var policyErr error
+	err := irodsAuth.pool.Run(func(irodsConn *irodsclient_conn.IRODSConnection) error {
+		var lookupErr error
+		// entries persisted by older versions have no zone
+		user, groupNames, lookupErr = irodsAuth.lookupUserWithConn(irodsConn, entry.Username, irodsAuth.config.IRODSZone)
+		if IsUserPolicyError(lookupErr) {
+			// the connection is fine
+			policyErr = lookupErr
+			return nil
+		}
+		return lookupErr
+	})
+	if err != nil {
+		if irodsclient_types.IsFileNotFoundError(err) {
+			logger.Infof("evicting %s, user %s no longer exists", entry.DN, entry.Username)
+			return nil, nil, false, nil
+		}
+		return nil, nil, false, err
+	}
+
+	if policyErr != nil {
+		logger.Infof("evicting %s, %v", entry.DN, policyErr)
+		return nil, nil, false, nil
+	}
+
+	return user, groupNames, true, nil
+}
@@ -0,0 +1,80 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// newTestNamingContext creates a naming context of the zone, without connecting to iRODS
+func newTestNamingContext(t *testing.T, baseDN string, zone string, serviceAccount bool) *NamingContext {
+	config := commons.NewDefaultConfig()
+	config.IRODSHost = "data.example.org"
+	config.IRODSZone = zone
+	config.LDAPBaseDN = baseDN
+	config.AuthCacheCleanupInterval = 0
+	if serviceAccount {
+		config.IRODSAdminUsername = "rods"
+		config.IRODSAdminPassword = "secret"
+	}
+
+	authCache, err := NewAuthCache(config)
+	if err != nil {
+		t.Fatalf("failed to create an auth cache - %v", err)
+	}
+
+	namingContext, err := NewNamingContext(config, newTestHasher(t), authCache)
+	if err != nil {
+		t.Fatalf("failed to create a naming context - %v", err)
+	}
+	return namingContext
+}
+
+func TestGroupRevalidationEntries(t *testing.T) {
+	parent := newTestNamingContext(t, "dc=example,dc=org", "iplant", true)
+	defer parent.Release()
+	child := newTestNamingContext(t, "dc=prod,dc=example,dc=org", "prod", true)
+	defer child.Release()
+	noAccount := newTestNamingContext(t, "dc=other,dc=org", "other", false)
+	defer noAccount.Release()
+
+	namingContexts := []*NamingContext{parent, child, noAccount}
+
+	cacheEntries := []AuthCacheEntry{
+		{DN: "uid=alice,ou=People,dc=example,dc=org", Username: "alice", Zone: "iplant"},
+		// persisted by older versions
+		{DN: "uid=bob,ou=People,dc=example,dc=org", Username: "bob"},
+		// most specific naming context
+		{DN: "uid=carol,ou=People,dc=prod,dc=example,dc=org", Username: "carol", Zone: "prod"},
+		// federated zone
+		{DN: "uid=dave#tempZone,ou=People,dc=example,dc=org", Username: "dave", Zone: "tempZone"},
+		// no service account
+		{DN: "uid=erin,ou=People,dc=other,dc=org", Username: "erin", Zone: "other"},
+		// no naming context
+		{DN: "uid=frank,ou=People,dc=unknown,dc=org", Username: "frank", Zone: "iplant"},
+	}
+
+	entries, unchecked := groupRevalidationEntries(namingContexts, cacheEntries)
+	if unchecked != 3 {
+		t.Errorf("expected 3 entries that cannot be checked, got %d", unchecked)
+	}
+
+	expected := map[*NamingContext][]string{
+		parent:    {"alice", "bob"},
+		child:     {"carol"},
+		noAccount: {},
+	}
+	for namingContext, usernames := range expected {
+		contextEntries := entries[namingContext]
+		if len(contextEntries) != len(usernames) {
+			t.Errorf("expected %v under %s, got %v", usernames, namingContext.GetBaseDN(), contextEntries)
+			continue
+		}
+
+		for i, username := range usernames {
+			if contextEntries[i].Username != username {
+				t.Errorf("expected %s under %s, got %s", username, namingContext.GetBaseDN(), contextEntries[i].Username)
+			}
+		}
+	}
+}
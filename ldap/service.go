@@ -1,22 +1,39 @@
 package ldap
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/cyverse/ldap-irods-auth/commons"
-	ldap_message "github.com/lor00x/goldap/message"
 	log "github.com/sirupsen/logrus"
 	"github.com/vjeantet/ldapserver"
 )
 
 // LDAPService is a service object
 type LDAPService struct {
-	config     *commons.Config
-	ldapServer *ldapserver.Server
-	irodsAuth  *IRODSAuth
-	terminate  bool
-	mutex      sync.Mutex
+	config           *commons.Config
+	ldapServer       *ldapserver.Server
+	ldapsServer      *ldapserver.Server // nil if LDAPS is disabled
+	certificates     *CertificateStore  // nil if LDAPS is disabled
+	listener         net.Listener       // bound by Listen
+	tlsListener      net.Listener       // bound by Listen, nil if LDAPS is disabled
+	limitListener    *limitListener     // set by Start
+	tlsLimitListener *limitListener     // set by Start, nil if LDAPS is disabled
+	authCache        *AuthCache
+	namingContexts   []*NamingContext
+	revalidator      *Revalidator // nil if revalidation is disabled
+	authGuard        *AuthGuard
+	rateLimiter      *RateLimiter
+	terminate        bool
+	shutdownChan     chan bool // closed when Shutdown finishes
+	mutex            sync.Mutex
+
+	// DNs bound on client connections, keyed by remote address
+	boundDNs     map[string]string
+	boundDNMutex sync.Mutex
 }
 
 // NewLDAP creates a new LDAP service
@@ -29,15 +46,44 @@ func NewLDAP(config *commons.Config) (*LDAPService, error) {
 	server := ldapserver.NewServer()
 	routes := ldapserver.NewRouteMux()
 
-	irodsAuth, err := NewIRODSAuth(config)
+	hasher, err := NewCredentialHasher(config)
 	if err != nil {
 		return nil, err
 	}
 
+	authCache, err := NewAuthCache(config)
+	if err != nil {
+		return nil, err
+	}
+
+	namingContexts := []*NamingContext{}
+	for _, contextConfig := range config.GetNamingContexts() {
+		namingContext, err := NewNamingContext(contextConfig, hasher, authCache)
+		if err != nil {
+			for _, created := range namingContexts {
+				created.Release()
+			}
+			authCache.Release()
+			return nil, err
+		}
+
+		namingContexts = append(namingContexts, namingContext)
+	}
+
 	svc := &LDAPService{
-		config:     config,
-		ldapServer: server,
-		irodsAuth:  irodsAuth,
+		config:         config,
+		ldapServer:     server,
+		authCache:      authCache,
+		namingContexts: namingContexts,
+		authGuard:      NewAuthGuard(config, hasher),
+		rateLimiter:    NewRateLimiter(config),
+		shutdownChan:   make(chan bool),
+		boundDNs:       map[string]string{},
+	}
+
+	if config.AuthRevalidationInterval > 0 {
+		svc.revalidator = NewRevalidator(namingContexts, authCache, time.Duration(config.AuthRevalidationInterval)*time.Second)
+		svc.revalidator.Start()
 	}
 
 	routes.NotFound(svc.handleNotFound)
@@ -46,9 +92,119 @@ func NewLDAP(config *commons.Config) (*LDAPService, error) {
 	routes.Search(svc.handleSearch).Label("Search - Generic")
 	server.Handle(routes)
 
+	if config.ServiceTLSPort > 0 {
+		certificates, err := NewCertificateStore(config)
+		if err != nil {
+			svc.releaseNamingContexts()
+			return nil, err
+		}
+
+		svc.certificates = certificates
+		svc.ldapsServer = ldapserver.NewServer()
+		svc.ldapsServer.Handle(routes)
+	}
+
 	return svc, nil
 }
 
+// Listen binds the listening sockets, clients can connect once it returns.
+// Sockets passed by systemd socket activation are used instead of binding the service ports
+func (svc *LDAPService) Listen() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "LDAPService",
+		"function": "Listen",
+	})
+
+	svc.mutex.Lock()
+	defer svc.mutex.Unlock()
+
+	if svc.listener != nil {
+		// already bound
+		return nil
+	}
+
+	listener, tlsListener, err := getActivatedListeners()
+	if err != nil {
+		return err
+	}
+
+	if tlsListener != nil && svc.ldapsServer == nil {
+		tlsListener.Close()
+		if listener != nil {
+			listener.Close()
+		}
+		return fmt.Errorf("socket for LDAPS is passed by systemd, but LDAPS is not enabled")
+	}
+
+	if listener != nil {
+		logger.Infof("Listening on %s, passed by systemd", listener.Addr().String())
+	} else {
+		hostport := fmt.Sprintf("%s:%d", svc.config.ServiceHost, svc.config.ServicePort)
+		listener, err = net.Listen("tcp", hostport)
+		if err != nil {
+			if tlsListener != nil {
+				tlsListener.Close()
+			}
+			return fmt.Errorf("failed to listen on %s - %v", hostport, err)
+		}
+		logger.Infof("Listening on %s", listener.Addr().String())
+	}
+
+	if svc.ldapsServer != nil {
+		if tlsListener != nil {
+			logger.Infof("Listening on %s for LDAPS, passed by systemd", tlsListener.Addr().String())
+		} else {
+			tlsHostport := fmt.Sprintf("%s:%d", svc.config.ServiceHost, svc.config.ServiceTLSPort)
+			tlsListener, err = net.Listen("tcp", tlsHostport)
+			if err != nil {
+				listener.Close()
+				return fmt.Errorf("failed to listen on %s - %v", tlsHostport, err)
+			}
+			logger.Infof("Listening on %s for LDAPS", tlsListener.Addr().String())
+		}
+
+		svc.tlsListener = tlsListener
+	}
+
+	svc.listener = listener
+	return nil
+}
+
+// getActivatedListeners returns listeners for LDAP and LDAPS passed by systemd socket activation, nil if not passed
+func getActivatedListeners() (net.Listener, net.Listener, error) {
+	listeners, names, err := commons.GetSystemdListeners()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var listener net.Listener
+	var tlsListener net.Listener
+	for i, activatedListener := range listeners {
+		if names[i] == commons.SystemdSocketNameLDAPS {
+			if tlsListener == nil {
+				tlsListener = activatedListener
+				continue
+			}
+		} else if listener == nil {
+			listener = activatedListener
+			continue
+		}
+
+		// one socket is served for each
+		for _, activatedListener := range listeners {
+			activatedListener.Close()
+		}
+
+		if names[i] == commons.SystemdSocketNameLDAPS {
+			return nil, nil, fmt.Errorf("more than one socket for LDAPS is passed by systemd")
+		}
+		return nil, nil, fmt.Errorf("more than one socket for LDAP is passed by systemd")
+	}
+	return listener, tlsListener, nil
+}
+
+// Start serves on the listening sockets, binding them first if not bound
 func (svc *LDAPService) Start() error {
 	logger := log.WithFields(log.Fields{
 		"package":  "ldap",
@@ -56,34 +212,359 @@ func (svc *LDAPService) Start() error {
 		"function": "Start",
 	})
 
+	err := svc.Listen()
+	if err != nil {
+		return err
+	}
+
 	logger.Info("Starting the LDAP-iRODS-Auth service")
 
-	// start service
-	hostport := fmt.Sprintf("%s:%d", svc.config.ServiceHost, svc.config.ServicePort)
-	return svc.ldapServer.ListenAndServe(hostport)
+	svc.mutex.Lock()
+	if svc.terminate {
+		// stopped before serving
+		svc.mutex.Unlock()
+		<-svc.shutdownChan
+		return nil
+	}
+
+	// connection limits apply to each listener
+	svc.limitListener = newLimitListener(svc.listener, svc.config.MaxConnections, svc.config.MaxConnectionsPerIP, svc.handleConnectionClose)
+	if svc.ldapsServer != nil {
+		svc.tlsLimitListener = newLimitListener(svc.tlsListener, svc.config.MaxConnections, svc.config.MaxConnectionsPerIP, svc.handleConnectionClose)
+	}
+	svc.mutex.Unlock()
+
+	// returns when any of the servers stops
+	errChan := make(chan error, 2)
+
+	if svc.ldapsServer != nil {
+		go func() {
+			errChan <- serveLDAP(svc.ldapsServer, tls.NewListener(svc.tlsLimitListener, svc.certificates.NewTLSConfig()))
+		}()
+	}
+
+	go func() {
+		errChan <- serveLDAP(svc.ldapServer, svc.limitListener)
+	}()
+
+	err = <-errChan
+
+	svc.mutex.Lock()
+	terminate := svc.terminate
+	svc.mutex.Unlock()
+
+	if terminate {
+		// stopped by Shutdown, returns once connections are drained
+		<-svc.shutdownChan
+		return nil
+	}
+	return err
 }
 
-// Destroy destroys the LDAP service
-func (svc *LDAPService) Destroy() {
+// CheckLiveness checks if the service accepts connections, within the timeout.
+// iRODS is not checked, see CheckIRODS
+func (svc *LDAPService) CheckLiveness(timeout time.Duration) error {
+	svc.mutex.Lock()
+	limitListener := svc.limitListener
+	terminate := svc.terminate
+	svc.mutex.Unlock()
+
+	if terminate || limitListener == nil {
+		return fmt.Errorf("service is not serving")
+	}
+
+	return limitListener.Probe(timeout)
+}
+
+// CheckIRODS checks if iRODS of each naming context accepts connections, within the timeout
+func (svc *LDAPService) CheckIRODS(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, namingContext := range svc.namingContexts {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("iRODS check timed out")
+		}
+
+		err := namingContext.GetIRODSAuth().CheckIRODS(remaining)
+		if err != nil {
+			return fmt.Errorf("naming context %q - %v", namingContext.GetBaseDN(), err)
+		}
+	}
+	return nil
+}
+
+// Reload applies reloadable settings of the config without dropping client connections,
+// settings requiring a restart must be those in effect, see Config.MergeReloadable.
+// Nothing is applied if the TLS certificate cannot be loaded
+func (svc *LDAPService) Reload(config *commons.Config) error {
 	logger := log.WithFields(log.Fields{
 		"package":  "ldap",
 		"struct":   "LDAPService",
-		"function": "Destroy",
+		"function": "Reload",
 	})
 
 	svc.mutex.Lock()
 	defer svc.mutex.Unlock()
 
+	if svc.terminate {
+		return fmt.Errorf("service is terminated")
+	}
+
+	if svc.certificates != nil && len(config.TLSCertPath) > 0 {
+		err := svc.certificates.Load(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	svc.authCache.Reconfigure(config)
+	svc.authGuard.Reconfigure(config)
+	svc.rateLimiter.Reconfigure(config)
+
+	contextConfigs := map[string]*commons.Config{}
+	for _, contextConfig := range config.GetNamingContexts() {
+		contextConfigs[contextConfig.LDAPBaseDN] = contextConfig
+	}
+
+	for _, namingContext := range svc.namingContexts {
+		contextConfig, ok := contextConfigs[namingContext.GetBaseDN()]
+		if !ok {
+			logger.Warnf("Naming context %q is not in the config, keeping it until restart", namingContext.GetBaseDN())
+			continue
+		}
+
+		namingContext.Reload(contextConfig)
+	}
+
+	logger.Info("Reloaded the configuration")
+	return nil
+}
+
+// Destroy destroys the LDAP service, draining connections within the configured timeout
+func (svc *LDAPService) Destroy() {
+	svc.Shutdown(time.Duration(svc.config.ShutdownDrainTimeout) * time.Second)
+}
+
+// Shutdown stops accepting connections and lets in-flight operations finish within the timeout.
+// Clients receive Notice of Disconnection. Connections remaining after the timeout are closed,
+// and iRODS connections are closed once operations still running end within their iRODS timeouts
+func (svc *LDAPService) Shutdown(timeout time.Duration) {
+	logger := log.WithFields(log.Fields{
+		"package":  "ldap",
+		"struct":   "LDAPService",
+		"function": "Shutdown",
+	})
+
+	svc.mutex.Lock()
 	if svc.terminate {
 		// already terminated
+		svc.mutex.Unlock()
 		return
 	}
 
 	svc.terminate = true
+	svc.mutex.Unlock()
+
+	defer close(svc.shutdownChan)
 
-	logger.Info("Destroying the LDAP-iRODS-Auth service")
+	logger.Infof("Shutting down the LDAP-iRODS-Auth service, draining connections for up to %s", timeout.String())
+
+	// ldapserver notifies clients and waits for their operations
+	drainChan := make(chan bool)
+	go func() {
+		var waitGroup sync.WaitGroup
+		for _, server := range []*ldapserver.Server{svc.ldapServer, svc.ldapsServer} {
+			if server == nil {
+				continue
+			}
+
+			waitGroup.Add(1)
+			go func(server *ldapserver.Server) {
+				defer waitGroup.Done()
+				server.Stop()
+			}(server)
+		}
 
-	svc.ldapServer.Stop()
+		waitGroup.Wait()
+		close(drainChan)
+	}()
+
+	svc.closeListeners()
+
+	select {
+	case <-drainChan:
+		logger.Info("All client connections are closed")
+	case <-time.After(timeout):
+		closed := svc.closeConnections()
+		logger.Warnf("Operations did not finish in %s, closed %d remaining connections", timeout.String(), closed)
+
+		// handlers still use iRODS connections and the auth cache
+		<-drainChan
+		logger.Info("All in-flight operations finished")
+	}
+
+	svc.releaseNamingContexts()
+}
+
+// closeListeners stops accepting connections
+func (svc *LDAPService) closeListeners() {
+	svc.mutex.Lock()
+	defer svc.mutex.Unlock()
+
+	// limit listeners let ldapserver stop accepting when closed
+	if svc.limitListener != nil {
+		svc.limitListener.Close()
+	} else if svc.listener != nil {
+		svc.listener.Close()
+	}
+
+	if svc.tlsLimitListener != nil {
+		svc.tlsLimitListener.Close()
+	} else if svc.tlsListener != nil {
+		svc.tlsListener.Close()
+	}
+}
+
+// closeConnections closes client connections, returns the number of connections closed
+func (svc *LDAPService) closeConnections() int {
+	svc.mutex.Lock()
+	defer svc.mutex.Unlock()
+
+	closed := 0
+	for _, listener := range []*limitListener{svc.limitListener, svc.tlsLimitListener} {
+		if listener != nil {
+			closed += listener.CloseConnections()
+		}
+	}
+	return closed
+}
+
+// releaseNamingContexts stops revalidation, releases naming contexts and the auth cache
+func (svc *LDAPService) releaseNamingContexts() {
+	if svc.revalidator != nil {
+		svc.revalidator.Stop()
+	}
+
+	for _, namingContext := range svc.namingContexts {
+		namingContext.Release()
+	}
+	svc.authCache.Release()
+}
+
+// ListCacheEntries returns unexpired auth cache entries
+func (svc *LDAPService) ListCacheEntries() []AuthCacheEntry {
+	return svc.authCache.List()
+}
+
+// InvalidateCacheDN removes the auth cache entry of the DN, normalized as the bind name is
+func (svc *LDAPService) InvalidateCacheDN(dn string) bool {
+	_, identity, err := svc.normalizeBindName(dn)
+	if err == nil {
+		dn = identity.DN
+	}
+	return svc.authCache.Delete(dn)
+}
+
+// InvalidateCacheGroup removes auth cache entries of members of the iRODS group
+func (svc *LDAPService) InvalidateCacheGroup(group string) int {
+	return svc.authCache.DeleteByGroup(group)
+}
+
+// FlushCache removes all auth cache entries
+func (svc *LDAPService) FlushCache() int {
+	return svc.authCache.Flush()
+}
+
+// ListLockouts returns DNs and source IPs locked out after failed binds
+func (svc *LDAPService) ListLockouts() []AuthLockout {
+	return svc.authGuard.ListLockouts()
+}
+
+// GetAuthGuardStats returns counters of failed binds
+func (svc *LDAPService) GetAuthGuardStats() AuthGuardStats {
+	return svc.authGuard.GetStats()
+}
+
+// TestAuth authenticates the bind name as a bind does, reporting each step to the tracer.
+// Failure counters and rate limits are not applied
+func (svc *LDAPService) TestAuth(dn string, password string, tracer AuthTracer) (bool, error) {
+	namingContext, identity, err := svc.normalizeBindName(dn)
+	if err != nil {
+		tracer("bind name", "", err)
+		return false, err
+	}
+	tracer("bind name", fmt.Sprintf("%s, naming context %s", identity.DN, namingContext.GetBaseDN()), nil)
+
+	irodsAuth := namingContext.GetIRODSAuth()
+	irodsAuth.SetTracer(tracer)
+	defer irodsAuth.SetTracer(nil)
+
+	return irodsAuth.Auth(identity, password)
+}
+
+// TestSearch searches as a search request does, with the auth cache entries given
+func (svc *LDAPService) TestSearch(baseDN string, filter string, attributes []string, cacheEntries []AuthCacheEntry) ([]*UserEntry, error) {
+	namingContext := svc.getNamingContext(baseDN)
+	if namingContext == nil {
+		return nil, fmt.Errorf("no naming context for base DN %q", baseDN)
+	}
+
+	requested := map[string]string{}
+	for _, attribute := range attributes {
+		requested[attribute] = attribute
+	}
+
+	contextEntries := []AuthCacheEntry{}
+	for _, entry := range cacheEntries {
+		if IsDNUnderBaseDN(namingContext.GetBaseDN(), entry.DN) {
+			contextEntries = append(contextEntries, entry)
+		}
+	}
+
+	return namingContext.Search(filter, requested, contextEntries), nil
+}
+
+// getNamingContext returns the naming context serving the dn.
+// With a single naming context, it serves all DNs for compatibility
+func (svc *LDAPService) getNamingContext(dn string) *NamingContext {
+	namingContext := findNamingContext(svc.namingContexts, dn)
+	if namingContext == nil && len(svc.namingContexts) == 1 {
+		return svc.namingContexts[0]
+	}
+	return namingContext
+}
+
+// handleConnectionClose cleans up per-connection state
+func (svc *LDAPService) handleConnectionClose(conn net.Conn) {
+	svc.setBoundDN(conn.RemoteAddr(), "")
+}
+
+// setBoundDN records the DN bound on the client connection, empty DN clears it
+func (svc *LDAPService) setBoundDN(addr net.Addr, dn string) {
+	if addr == nil {
+		return
+	}
+
+	svc.boundDNMutex.Lock()
+	defer svc.boundDNMutex.Unlock()
+
+	if len(dn) == 0 {
+		delete(svc.boundDNs, addr.String())
+		return
+	}
+	svc.boundDNs[addr.String()] = dn
+}
+
+// getBoundDN returns the DN bound on the client connection
+func (svc *LDAPService) getBoundDN(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	svc.boundDNMutex.Lock()
+	defer svc.boundDNMutex.Unlock()
+
+	return svc.boundDNs[addr.String()]
 }
 
 func (svc *LDAPService) handleNotFound(w ldapserver.ResponseWriter, m *ldapserver.Message) {
@@ -110,8 +591,21 @@ func (svc *LDAPService) handleAbandon(w ldapserver.ResponseWriter, m *ldapserver
 
 func (svc *LDAPService) handleBind(w ldapserver.ResponseWriter, m *ldapserver.Message) {
 	r := m.GetBindRequest()
+
+	// a new bind resets the authentication state of the connection
+	svc.setBoundDN(m.Client.Addr(), "")
+
 	if r.AuthenticationChoice() == "simple" {
 		dn := string(r.Name())
+		clientIP := getClientIP(m)
+		irodsPassword := string(r.AuthenticationSimple())
+
+		// the client IP is limited before the bind name is normalized, which may query iRODS
+		if !svc.rateLimiter.Allow(clientIP, "") {
+			svc.writeBindBusy(w, dn, clientIP)
+			return
+		}
+
 		if dn == "" {
 			// anonymous access
 			log.Printf("Anonymous user bind")
@@ -119,19 +613,37 @@ func (svc *LDAPService) handleBind(w ldapserver.ResponseWriter, m *ldapserver.Me
 			return
 		}
 
-		irodsPassword := string(r.AuthenticationSimple())
+		namingContext, identity, err := svc.normalizeBindName(dn)
+		if err != nil {
+			svc.writeBindFailure(w, dn, irodsPassword, clientIP, err)
+			return
+		}
+
+		// all forms of a name share the DN limit and failure counters
+		if !svc.rateLimiter.Allow("", identity.DN) {
+			svc.writeBindBusy(w, identity.DN, clientIP)
+			return
+		}
+
+		err = svc.authGuard.Check(identity.DN, irodsPassword, clientIP)
+		if err != nil {
+			log.Printf("Bind rejected User=%s, Client=%s - %v", identity.DN, clientIP, err)
+			failRes := ldapserver.NewBindResponse(ldapserver.LDAPResultInvalidCredentials)
+			failRes.SetDiagnosticMessage("invalid credentials")
+			w.Write(failRes)
+			return
+		}
 
-		authSuccess, _ := svc.irodsAuth.Auth(dn, irodsPassword)
+		authSuccess, err := namingContext.GetIRODSAuth().Auth(identity, irodsPassword)
 		if authSuccess {
-			log.Printf("Bind User=%s", dn)
+			svc.authGuard.Succeed(identity.DN, clientIP)
+			svc.setBoundDN(m.Client.Addr(), identity.DN)
+			log.Printf("Bind User=%s", identity.DN)
 			w.Write(ldapserver.NewBindResponse(ldapserver.LDAPResultSuccess))
 			return
 		}
 
-		log.Printf("Bind failed User=%s", dn)
-		failRes := ldapserver.NewBindResponse(ldapserver.LDAPResultInvalidCredentials)
-		failRes.SetDiagnosticMessage("invalid credentials")
-		w.Write(failRes)
+		svc.writeBindFailure(w, identity.DN, irodsPassword, clientIP, err)
 		return
 	}
 
@@ -141,8 +653,66 @@ func (svc *LDAPService) handleBind(w ldapserver.ResponseWriter, m *ldapserver.Me
 	w.Write(failRes)
 }
 
+// normalizeBindName returns the naming context and the normalized identity of the bind name
+func (svc *LDAPService) normalizeBindName(dn string) (*NamingContext, *BindIdentity, error) {
+	namingContext := findNamingContext(svc.namingContexts, dn)
+	if namingContext == nil {
+		return nil, nil, fmt.Errorf("no naming context for DN %q", dn)
+	}
+
+	identity, err := namingContext.GetIRODSAuth().NormalizeBindName(dn)
+	if err != nil {
+		return nil, nil, err
+	}
+	return namingContext, identity, nil
+}
+
+// writeBindBusy responds to a rate limited bind
+func (svc *LDAPService) writeBindBusy(w ldapserver.ResponseWriter, dn string, clientIP string) {
+	log.Printf("Bind rate limited User=%s, Client=%s", dn, clientIP)
+	busyRes := ldapserver.NewBindResponse(ldapserver.LDAPResultBusy)
+	busyRes.SetDiagnosticMessage("too many requests")
+	w.Write(busyRes)
+}
+
+// writeBindFailure responds to a failed bind, counting it as a failure unless iRODS is unavailable
+func (svc *LDAPService) writeBindFailure(w ldapserver.ResponseWriter, dn string, password string, clientIP string, err error) {
+	if IsIRODSUnavailableError(err) {
+		// not a credential problem, do not count it as a failure
+		log.Printf("Bind failed User=%s - %v", dn, err)
+		failRes := ldapserver.NewBindResponse(ldapserver.LDAPResultUnavailable)
+		failRes.SetDiagnosticMessage("authentication service unavailable")
+		w.Write(failRes)
+		return
+	}
+
+	svc.authGuard.Fail(dn, password, clientIP)
+
+	if IsUserPolicyError(err) {
+		log.Printf("Bind denied User=%s - %v", dn, err)
+	} else {
+		log.Printf("Bind failed User=%s - %v", dn, err)
+	}
+	failRes := ldapserver.NewBindResponse(ldapserver.LDAPResultInvalidCredentials)
+	failRes.SetDiagnosticMessage("invalid credentials")
+	w.Write(failRes)
+}
+
+// getClientIP returns IP address of the client that sent the message
+func getClientIP(m *ldapserver.Message) string {
+	return getAddrIP(m.Client.Addr())
+}
+
 func (svc *LDAPService) handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message) {
 	r := m.GetSearchRequest()
+
+	clientIP := getClientIP(m)
+	if !svc.rateLimiter.Allow(clientIP, svc.getBoundDN(m.Client.Addr())) {
+		log.Printf("Search rate limited Client=%s", clientIP)
+		w.Write(ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultBusy))
+		return
+	}
+
 	log.Printf("Request BaseDn=%s", r.BaseObject())
 	log.Printf("Request Filter=%s", r.Filter())
 	log.Printf("Request FilterString=%s", r.FilterString())
@@ -157,69 +727,22 @@ func (svc *LDAPService) handleSearch(w ldapserver.ResponseWriter, m *ldapserver.
 	default:
 	}
 
+	namingContext := svc.getNamingContext(string(r.BaseObject()))
+	if namingContext == nil {
+		log.Printf("No naming context for BaseDn=%s", r.BaseObject())
+		w.Write(ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultNoSuchObject))
+		return
+	}
+
 	// to quick search
 	attributes := map[string]string{}
 	for _, att := range r.Attributes() {
 		attributes[string(att)] = string(att)
 	}
 
-	// included all id
-	usersAdded := map[string]string{}
-
-	dns := svc.irodsAuth.GetDNs()
-	for _, dn := range dns {
-		username := GetUsernameFromDN(dn)
-		filter := r.FilterString()
-		if CheckDNFilter(filter, dn) {
-			log.Printf("Returning search result - %s", dn)
-
-			e := ldapserver.NewSearchResultEntry(dn)
-			if len(attributes) == 0 {
-				// display all
-				e.AddAttribute("mail", ldap_message.AttributeValue(username+"@cyverse.org"))
-				e.AddAttribute("cn", ldap_message.AttributeValue(username))
-			} else {
-				if _, ok := attributes["uid"]; ok {
-					e.AddAttribute("uid", ldap_message.AttributeValue(username))
-				}
-
-				if _, ok := attributes["cn"]; ok {
-					e.AddAttribute("cn", ldap_message.AttributeValue(username))
-				}
-			}
-
-			w.Write(e)
-
-			usersAdded[username] = dn
-		}
-	}
-
-	// include asked id
-	askedUser := ExtractFilterValue(r.FilterString(), "uid")
-	log.Printf("asked user := %s", askedUser)
-	if _, ok := usersAdded[askedUser]; !ok {
-		// not added
-		// make a new dn
-		log.Printf("Adding an asked user %s to search result", askedUser)
-		dn := fmt.Sprintf("uid=%s,ou=People,%s", askedUser, r.BaseObject())
-
-		e := ldapserver.NewSearchResultEntry(dn)
-		if len(attributes) == 0 {
-			// display all
-			e.AddAttribute("mail", ldap_message.AttributeValue(askedUser+"@cyverse.org"))
-			e.AddAttribute("cn", ldap_message.AttributeValue(askedUser))
-		} else {
-			if _, ok := attributes["uid"]; ok {
-				e.AddAttribute("uid", ldap_message.AttributeValue(askedUser))
-			}
-
-			if _, ok := attributes["cn"]; ok {
-				e.AddAttribute("cn", ldap_message.AttributeValue(askedUser))
-			}
-		}
-		w.Write(e)
-
-		usersAdded[askedUser] = dn
+	cacheEntries := namingContext.GetIRODSAuth().ListCacheEntries()
+	for _, userEntry := range namingContext.Search(r.FilterString(), attributes, cacheEntries) {
+		w.Write(userEntry.SearchResultEntry())
 	}
 
 	res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultSuccess)
@@ -0,0 +1,56 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/cyverse/ldap-irods-auth/commons"
+)
+
+// CertificateStore holds the TLS certificate served by the LDAPS listener, replaced on reload
+// without affecting established connections
+type CertificateStore struct {
+	certificate *tls.Certificate
+	mutex       sync.RWMutex
+}
+
+// NewCertificateStore creates a new CertificateStore with the certificate and key of the config
+func NewCertificateStore(config *commons.Config) (*CertificateStore, error) {
+	store := &CertificateStore{}
+	err := store.Load(config)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load loads the certificate and key of the config, the current certificate is kept if they are invalid
+func (store *CertificateStore) Load(config *commons.Config) error {
+	certificate, err := config.LoadTLSCertificate()
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate %s - %v", config.TLSCertPath, err)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.certificate = &certificate
+	return nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (store *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.certificate, nil
+}
+
+// NewTLSConfig returns a TLS config serving the current certificate
+func (store *CertificateStore) NewTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: store.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}